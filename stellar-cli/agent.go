@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mua69/stellarwallet"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/xdr"
+)
+
+// Key agent: stellar-cli --agent <socket path> unlocks the wallet once and serves signing requests for
+// the wallet's private keys on a Unix domain socket, using the external signer protocol (see signer.go).
+// Other stellar-cli processes connect with --external-signer unix:<socket path>, or by setting the
+// environment variable STELLAR_CLI_AGENT=<socket path>. Clients prefer keys served by the agent and never
+// unlock their own wallet for them, but any other wallet operation still asks for the wallet password:
+// start clients with --no-wallet (or with a wallet without the agent's private keys) to keep the password
+// in the agent only.
+//
+// Policies (--agent-policy):
+//   auto    - sign requests without confirmation
+//   confirm - display each transaction on the agent's terminal and ask for confirmation
+// Keys given with --agent-confirm-key are always confirmed, also with policy auto. Policies per network
+// are not supported, an agent serves a single network: requests for another network are rejected.
+// The hash must match the transaction.
+//
+// The socket is created with permissions 0600, on Linux connections of other users are rejected
+// based on the peer credentials (SO_PEERCRED).
+// After --agent-idle seconds without a signing request the wallet password is erased, the next request
+// prompts for the password on the agent's terminal.
+
+const (
+	AgentPolicyAuto    = "auto"
+	AgentPolicyConfirm = "confirm"
+
+	agentSocketEnv = "STELLAR_CLI_AGENT"
+)
+
+type KeyAgent struct {
+	// serializes requests, the agent's terminal is used for confirmations and password prompts
+	mutex sync.Mutex
+	unlocked bool
+	lastUse time.Time
+}
+
+// prompts for the wallet password, the password stays locked against the reset daemon until agent.lock()
+// must be called with agent.mutex held
+func (agent *KeyAgent) unlock() bool {
+	if agent.unlocked {
+		return true
+	}
+
+	if !unlockWallet(true) {
+		return false
+	}
+
+	agent.unlocked = true
+	agent.lastUse = time.Now()
+
+	return true
+}
+
+// must be called with agent.mutex held
+func (agent *KeyAgent) lock() {
+	if agent.unlocked {
+		unlockWalletPassword()
+		lockWallet()
+		agent.unlocked = false
+	}
+}
+
+func (agent *KeyAgent) idleLockDaemon() {
+	for {
+		time.Sleep(time.Second)
+
+		agent.mutex.Lock()
+
+		if agent.unlocked && time.Since(agent.lastUse) >= time.Duration(g_agentIdle)*time.Second {
+			agent.lock()
+			fmt.Println("Key agent locked after idle timeout.")
+		}
+
+		agent.mutex.Unlock()
+	}
+}
+
+func (agent *KeyAgent) sign(req *SignerRequest, resp *SignerResponse) {
+	acc := g_wallet.FindAccountByPublicKey(req.PublicKey)
+
+	if acc == nil || !isSeedAccount(acc) {
+		resp.Error = "unknown public key " + req.PublicKey
+		return
+	}
+
+	if req.NetworkPassphrase != g_network.Passphrase {
+		resp.Error = "network mismatch, agent serves " + g_network.Passphrase
+		return
+	}
+
+	var txe xdr.TransactionEnvelope
+
+	if err := xdr.SafeUnmarshalBase64(req.Transaction, &txe); err != nil {
+		resp.Error = "invalid transaction: " + err.Error()
+		return
+	}
+
+	hash, err := network.HashTransaction(&txe.Tx, g_network.Passphrase)
+	if err != nil || hex.EncodeToString(hash[:]) != req.Hash {
+		resp.Error = "hash does not match transaction"
+		return
+	}
+
+	fmt.Printf("\nSigning request for %s %s, transaction %s\n", acc.PublicKey(), acc.Description(), req.Hash)
+
+	if !agent.unlocked {
+		fmt.Println("Key agent is locked, enter wallet password to serve the request or hit ENTER to reject.")
+		if !agent.unlock() {
+			resp.Error = "key agent is locked"
+			return
+		}
+	}
+
+	if g_agentPolicy == AgentPolicyConfirm || isAgentConfirmKey(acc.PublicKey()) {
+		print_transaction(&txe, "  ", os.Stdout)
+
+		if !getOk("Sign transaction") {
+			resp.Error = "signing rejected by agent user"
+			return
+		}
+	}
+
+	seed := acc.PrivateKey(&g_walletPassword)
+	defer stellarwallet.EraseString(&seed)
+
+	sig, err := keypair.MustParse(seed).Sign(hash[:])
+	if err != nil {
+		resp.Error = err.Error()
+		return
+	}
+
+	agent.lastUse = time.Now()
+
+	resp.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	fmt.Println("Transaction signed.")
+}
+
+func isAgentConfirmKey(pubkey string) bool {
+	for _, k := range g_agentConfirmKeys {
+		if k == pubkey {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (agent *KeyAgent) handleRequest(req *SignerRequest) *SignerResponse {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+
+	resp := &SignerResponse{Id: req.Id}
+
+	switch req.Method {
+	case SignerMethodPublicKeys:
+		for _, a := range g_wallet.SeedAccounts() {
+			resp.PublicKeys = append(resp.PublicKeys, a.PublicKey())
+		}
+
+	case SignerMethodSign:
+		agent.sign(req, resp)
+
+	default:
+		resp.Error = "unknown method " + req.Method
+	}
+
+	return resp
+}
+
+func (agent *KeyAgent) serve(r io.Reader, w io.Writer) {
+	scan := bufio.NewScanner(r)
+	scan.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	enc := json.NewEncoder(w)
+
+	for scan.Scan() {
+		var req SignerRequest
+
+		resp := &SignerResponse{}
+
+		if err := json.Unmarshal(scan.Bytes(), &req); err != nil {
+			resp.Error = "invalid request: " + err.Error()
+		} else {
+			resp = agent.handleRequest(&req)
+		}
+
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// runs the key agent until interrupted
+func runKeyAgent() {
+	if g_wallet == nil {
+		fmt.Println("Key agent requires a wallet.")
+		return
+	}
+
+	if g_agentPolicy != AgentPolicyAuto && g_agentPolicy != AgentPolicyConfirm {
+		fmt.Printf("Invalid agent policy: %s\n", g_agentPolicy)
+		return
+	}
+
+	if g_agentIdle < 1 {
+		fmt.Println("Invalid agent idle time.")
+		return
+	}
+
+	for _, k := range g_agentConfirmKeys {
+		if !isValidPublicKey(k) {
+			fmt.Printf("Invalid public key for confirmation: %s\n", k)
+			return
+		}
+	}
+
+	agent := new(KeyAgent)
+
+	fmt.Println("Unlocking wallet for key agent.")
+
+	agent.mutex.Lock()
+	ok := agent.unlock()
+	agent.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	// remove a stale socket of a previous agent, never other files
+	if info, err := os.Lstat(g_agentSocket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(g_agentSocket)
+	}
+
+	l, err := listenAgentSocket(g_agentSocket)
+	if err != nil {
+		fmt.Printf("Failed to listen on %s: %s\n", g_agentSocket, err.Error())
+		return
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-sigs
+		l.Close()
+	}()
+
+	go agent.idleLockDaemon()
+
+	fmt.Printf("Key agent listening on %s, policy %s, idle lock after %d seconds.\n", g_agentSocket, g_agentPolicy, g_agentIdle)
+	fmt.Printf("Use: %s=%s stellar-cli --no-wallet ...\n", agentSocketEnv, g_agentSocket)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			break
+		}
+
+		if err = checkAgentPeer(conn); err != nil {
+			fmt.Printf("Key agent connection rejected: %s\n", err.Error())
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			agent.serve(conn, conn)
+			conn.Close()
+		}()
+	}
+
+	os.Remove(g_agentSocket)
+
+	agent.mutex.Lock()
+	agent.lock()
+	agent.mutex.Unlock()
+
+	fmt.Println("Key agent stopped.")
+}
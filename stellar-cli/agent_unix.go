@@ -0,0 +1,17 @@
+// +build !windows
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// creates the agent socket with permissions 0600, the umask is restricted while the socket is bound
+// so that the socket is never accessible to other users
+func listenAgentSocket(path string) (net.Listener, error) {
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", path)
+}
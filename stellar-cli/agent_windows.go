@@ -0,0 +1,12 @@
+// +build windows
+
+package main
+
+import (
+	"net"
+)
+
+// access to the socket is controlled by the permissions of its directory
+func listenAgentSocket(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
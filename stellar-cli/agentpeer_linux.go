@@ -0,0 +1,45 @@
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// rejects connections of processes running as another user than the agent
+func checkAgentPeer(conn net.Conn) error {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("not a Unix socket connection")
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+
+	if err == nil {
+		err = credErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("cannot get peer credentials: %s", err.Error())
+	}
+
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("peer uid %d differs from agent uid %d", cred.Uid, os.Getuid())
+	}
+
+	return nil
+}
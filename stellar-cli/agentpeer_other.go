@@ -0,0 +1,12 @@
+// +build !linux
+
+package main
+
+import (
+	"net"
+)
+
+// peer credentials are only checked on Linux, access is restricted by the socket permissions
+func checkAgentPeer(conn net.Conn) error {
+	return nil
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mua69/stellarwallet"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/stellar/go/xdr"
+)
+
+// Air-gap transfer workflow:
+// The online machine writes unsigned transaction files (tx_*.txt) to the inbox directory, which is
+// transferred to the offline machine. The offline machine signs selected inbox transactions and writes
+// the signed transaction files (txs_*.txt) to the outbox directory, processed inbox files are moved to
+// the archive directory. The outbox is transferred back and the online machine submits all outbox
+// transactions, archiving each transaction file together with a result file.
+
+type TransactionFile struct {
+	name string
+	hdr *TransactionFileHeader
+	txe *xdr.TransactionEnvelope
+}
+
+func setupTransferDirectories() {
+	for _, dir := range []string{g_inboxDir, g_outboxDir, g_archiveDir} {
+		if dir != "" {
+			err := os.MkdirAll(dir, 0700)
+			if err != nil {
+				fmt.Printf("Failed to create directory \"%s\": %s\n", dir, err.Error())
+			}
+		}
+	}
+}
+
+func transferArchiveDir(dir string) string {
+	if g_archiveDir != "" {
+		return g_archiveDir
+	}
+
+	return filepath.Join(dir, "archive")
+}
+
+// moves a processed transaction file to the archive directory
+func archiveTransactionFile(fileName, archiveDir string) {
+	err := os.MkdirAll(archiveDir, 0700)
+
+	if err == nil {
+		err = os.Rename(fileName, filepath.Join(archiveDir, filepath.Base(fileName)))
+	}
+
+	if err != nil {
+		fmt.Printf("Failed to archive file \"%s\": %s\n", fileName, err.Error())
+	}
+}
+
+// returns transaction files (tx_*.txt or txs_*.txt) in given directory sorted by name
+func listTransactionFiles(dir string, signed bool) []string {
+	pattern := "tx_*.txt"
+	if signed {
+		pattern = "txs_*.txt"
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, pattern))
+
+	if err != nil {
+		fmt.Printf("Failed to list directory \"%s\": %s\n", dir, err.Error())
+		return nil
+	}
+
+	sort.Strings(files)
+
+	return files
+}
+
+// reads and decodes transaction files, invalid files are reported and skipped
+func loadTransactionFiles(files []string) []*TransactionFile {
+	res := make([]*TransactionFile, 0, len(files))
+
+	for _, f := range files {
+		txe, hdr, err := readTransactionEnvelope(f)
+
+		if err != nil {
+			fmt.Printf("Skipping invalid transaction file \"%s\": %s\n", f, err.Error())
+			continue
+		}
+
+		res = append(res, &TransactionFile{f, hdr, txe})
+	}
+
+	return res
+}
+
+func printTransactionFiles(txfs []*TransactionFile) {
+	table := newCliTable(5)
+	table.setJustification(CliTableJustificationRight)
+
+	for i, tf := range txfs {
+		table.appendLine(fmt.Sprintf("%d", i+1), filepath.Base(tf.name), rawPublicKeyToString(tf.txe.Tx.SourceAccount),
+			fmt.Sprintf("%d op(s)", len(tf.txe.Tx.Operations)), fmt.Sprintf("%d signature(s)", len(tf.txe.Signatures)))
+	}
+
+	table.print()
+}
+
+// parses a selection like "1,3-5" or "a" (all), returns 0 based indices
+func parseSelection(s string, n int) ([]int, error) {
+	var res []int
+
+	s = strings.TrimSpace(s)
+
+	if s == "a" || s == "all" {
+		for i := 0; i < n; i++ {
+			res = append(res, i)
+		}
+		return res, nil
+	}
+
+	selected := make(map[int]bool)
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		r := strings.SplitN(part, "-", 2)
+
+		from, err := strconv.Atoi(strings.TrimSpace(r[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %s", r[0])
+		}
+
+		to := from
+		if len(r) == 2 {
+			to, err = strconv.Atoi(strings.TrimSpace(r[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid number: %s", r[1])
+			}
+		}
+
+		if from < 1 || to > n || from > to {
+			return nil, fmt.Errorf("invalid range: %s", part)
+		}
+
+		for i := from; i <= to; i++ {
+			if !selected[i-1] {
+				selected[i-1] = true
+				res = append(res, i-1)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func selectTransactionFiles(txfs []*TransactionFile) []*TransactionFile {
+	for {
+		s := readLine("Select transactions (e.g. 1,3-5, 'a' for all, hit enter to cancel)")
+
+		if s == "" {
+			return nil
+		}
+
+		sel, err := parseSelection(s, len(txfs))
+
+		if err != nil {
+			fmt.Printf("Invalid selection: %s\n", err.Error())
+			continue
+		}
+
+		res := make([]*TransactionFile, 0, len(sel))
+		for _, i := range sel {
+			res = append(res, txfs[i])
+		}
+
+		return res
+	}
+}
+
+// returns name of the signed version of a transaction file
+func signedTransactionFileName(fileName, outDir string) string {
+	base := filepath.Base(fileName)
+
+	if strings.HasPrefix(base, "tx_") {
+		base = "txs_" + base[3:]
+	} else {
+		base = strings.TrimSuffix(base, ".txt") + "_signed.txt"
+	}
+
+	if outDir == "" {
+		outDir = filepath.Dir(fileName)
+	}
+
+	return filepath.Join(outDir, base)
+}
+
+// signs the given transactions and writes the signed transactions to outDir, or next to the original files
+// if outDir is empty
+// keys of the signers file and keys entered manually are collected once for all transactions, each
+// transaction is signed only with the keys that are signers of its source accounts (see isSignerRelevant())
+// returns the successfully signed transaction files
+func signTransactionFiles(txfs []*TransactionFile, outDir string) []*TransactionFile {
+	defer clearSigners()
+
+	var common []string
+
+	defer func() {
+		for i := range common {
+			stellarwallet.EraseString(&common[i])
+		}
+	}()
+
+	// moves the keys read into g_signers to the common keys
+	collect := func() {
+		for _, seed := range g_signers {
+			common = append(common, string([]byte(seed)))
+		}
+		clearSigners()
+	}
+
+	// selects the common keys and the wallet and external signer keys for a transaction
+	selectSigners := func(tf *TransactionFile) []*AccountSignatureStatus {
+		clearSigners()
+		for _, seed := range common {
+			g_signers = append(g_signers, string([]byte(seed)))
+		}
+		return selectWalletSigners(&tf.txe.Tx, tf.txe.Signatures)
+	}
+
+	statuses := make([][]*AccountSignatureStatus, len(txfs))
+
+	missing := func() bool {
+		res := false
+		for i, tf := range txfs {
+			statuses[i] = selectSigners(tf)
+			if signingWeightMissing(statuses[i]) {
+				res = true
+			}
+		}
+		return res
+	}
+
+	if missing() {
+		clearSigners()
+
+		still := true
+
+		if readSignersFromFile() > 0 {
+			collect()
+			still = missing()
+		}
+
+		if still {
+			fmt.Println("\nMissing signing weight:")
+			for i, tf := range txfs {
+				if signingWeightMissing(statuses[i]) {
+					fmt.Printf("%s:\n", filepath.Base(tf.name))
+					printMissingSigningWeight(statuses[i])
+				}
+			}
+
+			clearSigners()
+			readSigners()
+			collect()
+		}
+	}
+
+	var signed []*TransactionFile
+
+	for _, tf := range txfs {
+		accounts := selectSigners(tf)
+
+		if signerCount() == 0 {
+			fmt.Printf("%s: no signing keys provided\n", filepath.Base(tf.name))
+			continue
+		}
+
+		cnt := signEnvelope(tf.txe, accounts)
+
+		if cnt == 0 {
+			fmt.Printf("%s: no matching signing key\n", filepath.Base(tf.name))
+			continue
+		}
+
+		blob, err := xdr.MarshalBase64(tf.txe)
+		if err != nil {
+			panic(err)
+		}
+
+		fileName := signedTransactionFileName(tf.name, outDir)
+
+		err = writeTransactionBlob(blob, tf.txe, fileName, tf.hdr.notes)
+
+		if err != nil {
+			fmt.Printf("Failed to write transaction blob to file \"%s\": %s\n", fileName, err.Error())
+			continue
+		}
+
+		fmt.Printf("%s: added %d signature(s), written to %s\n", filepath.Base(tf.name), cnt, fileName)
+
+		signed = append(signed, tf)
+	}
+
+	return signed
+}
+
+func listInbox() {
+	fmt.Printf("\nPending unsigned transactions in inbox %s:\n", g_inboxDir)
+
+	txfs := loadTransactionFiles(listTransactionFiles(g_inboxDir, false))
+
+	if len(txfs) == 0 {
+		fmt.Println("none")
+		return
+	}
+
+	printTransactionFiles(txfs)
+}
+
+func listOutbox() {
+	fmt.Printf("\nSigned transactions in outbox %s:\n", g_outboxDir)
+
+	txfs := loadTransactionFiles(listTransactionFiles(g_outboxDir, true))
+
+	if len(txfs) == 0 {
+		fmt.Println("none")
+		return
+	}
+
+	printTransactionFiles(txfs)
+}
+
+func signInbox() {
+	txfs := loadTransactionFiles(listTransactionFiles(g_inboxDir, false))
+
+	if len(txfs) == 0 {
+		fmt.Println("No pending transactions in inbox.")
+		return
+	}
+
+	fmt.Printf("\nPending unsigned transactions in inbox %s:\n", g_inboxDir)
+	printTransactionFiles(txfs)
+
+	txfs = selectTransactionFiles(txfs)
+
+	if len(txfs) == 0 {
+		return
+	}
+
+	for _, tf := range txfs {
+		fmt.Printf("\n%s:\n", filepath.Base(tf.name))
+		print_transaction(tf.txe, "  ", os.Stdout)
+	}
+
+	fmt.Println()
+	if !getOk(fmt.Sprintf("Sign %d transaction(s)", len(txfs))) {
+		return
+	}
+
+	for _, tf := range signTransactionFiles(txfs, g_outboxDir) {
+		archiveTransactionFile(tf.name, transferArchiveDir(g_inboxDir))
+	}
+}
+
+// writes the submission result of a transaction file to the archive directory
+func writeSubmissionResult(fileName, archiveDir string, txe *xdr.TransactionEnvelope,
+	resp *horizon.TransactionSuccess, err error) {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "File: %s\n", filepath.Base(fileName))
+	fmt.Fprintf(&b, "Hash: %s\n", transactionHashString(&txe.Tx))
+	fmt.Fprintf(&b, "Network: %s\n", g_network.Passphrase)
+	fmt.Fprintf(&b, "Submitted: %s\n", time.Now().Format(time.RFC3339))
+
+	if err == nil {
+		fmt.Fprintf(&b, "Result: success\nLedger: %d\n", resp.Ledger)
+	} else {
+		fmt.Fprintf(&b, "Result: failed\nError: %s\n", err.Error())
+		if herr, ok := err.(*horizon.Error); ok {
+			fmt.Fprintf(&b, "Result Codes: %s\n", string(herr.Problem.Extras["result_codes"]))
+		}
+	}
+
+	resultName := filepath.Join(archiveDir, strings.TrimSuffix(filepath.Base(fileName), ".txt") + ".result.txt")
+
+	werr := os.MkdirAll(archiveDir, 0700)
+	if werr == nil {
+		werr = ioutil.WriteFile(resultName, []byte(b.String()), 0600)
+	}
+
+	if werr != nil {
+		fmt.Printf("Failed to write result file \"%s\": %s\n", resultName, werr.Error())
+	}
+}
+
+func submitOutbox() {
+	txfs := loadTransactionFiles(listTransactionFiles(g_outboxDir, true))
+
+	if len(txfs) == 0 {
+		fmt.Println("No signed transactions in outbox.")
+		return
+	}
+
+	fmt.Printf("\nSigned transactions in outbox %s:\n", g_outboxDir)
+	printTransactionFiles(txfs)
+
+	fmt.Println()
+	if !getOk(fmt.Sprintf("Submit %d transaction(s)", len(txfs))) {
+		return
+	}
+
+	archiveDir := transferArchiveDir(g_outboxDir)
+
+	for _, tf := range txfs {
+		fmt.Printf("\nSubmitting %s...\n", filepath.Base(tf.name))
+
+		blob, err := xdr.MarshalBase64(tf.txe)
+		if err != nil {
+			panic(err)
+		}
+
+		resp, err := tx_transmit_blob(blob)
+
+		if err != nil {
+			if _, ok := err.(*horizon.Error); !ok {
+				// not processed by the network, keep file in outbox
+				continue
+			}
+		}
+
+		writeSubmissionResult(tf.name, archiveDir, tf.txe, resp, err)
+		archiveTransactionFile(tf.name, archiveDir)
+	}
+}
+
+func airGapMenu() {
+	menu := []MenuEntryCB{
+		{ listInbox, "List Inbox", g_inboxDir != ""},
+		{ signInbox, "Sign Inbox Transactions", g_inboxDir != "" && g_outboxDir != ""},
+		{ listOutbox, "List Outbox", g_outboxDir != ""},
+		{ submitOutbox, "Submit Outbox Transactions", g_outboxDir != "" && g_online}}
+
+	runCallbackMenu(menu, "AIR-GAP TRANSFER: Select Action", true)
+}
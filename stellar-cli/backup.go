@@ -0,0 +1,610 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mua69/stellarwallet"
+)
+
+// Wallet backup format:
+// The backup file consists of the format tag line followed by one line holding the encrypted backup
+// (base64 encoded scrypt salt, secretbox nonce and encrypted data, see encryptWithPassword()).
+// The decrypted data is a JSON document:
+//
+//   {
+//     "format": "stellar-cli-wallet-backup",
+//     "version": 1,
+//     "created": "2019-01-01T12:00:00Z",
+//     "wallet": "<stellarwallet export, encrypted with the wallet password at backup time>",
+//     "mnemonic": ["word1", ... "word24"],
+//     "accounts": [
+//       { "type": "sep0005|random|watching|address_book", "public_key": "G...", "private_key": "S...",
+//         "description": "...", "memo_text": "...", "memo_id": 123 }
+//     ],
+//     "assets": [ { "code": "EURT", "issuer": "G...", "description": "..." } ],
+//     "trading_pairs": [
+//       { "asset1": { "code": "XLM" }, "asset2": { "code": "EURT", "issuer": "G..." }, "description": "..." }
+//     ]
+//   }
+//
+// "private_key" is present for random and sep0005 accounts only, "mnemonic" holds the wallet's mnemonic
+// words (a mnemonic password, if used, is not part of the backup). The "wallet" member restores the wallet
+// as is, the other members allow restoring the content independently of the wallet library.
+// The public export uses the same document without "wallet", "mnemonic" and private keys and is not encrypted.
+
+const (
+	backupFormatTag = "STELLAR-CLI-BACKUP-1"
+	backupFormat = "stellar-cli-wallet-backup"
+	backupFormatPublic = "stellar-cli-wallet-public"
+	backupVersion = 1
+
+	BackupAccountTypeSEP0005 = "sep0005"
+	BackupAccountTypeRandom = "random"
+	BackupAccountTypeWatching = "watching"
+	BackupAccountTypeAddressBook = "address_book"
+)
+
+type WalletBackup struct {
+	Format       string               `json:"format"`
+	Version      int                  `json:"version"`
+	Created      time.Time            `json:"created"`
+	Wallet       string               `json:"wallet,omitempty"`
+	Mnemonic     []string             `json:"mnemonic,omitempty"`
+	Accounts     []*BackupAccount     `json:"accounts"`
+	Assets       []*BackupAsset       `json:"assets"`
+	TradingPairs []*BackupTradingPair `json:"trading_pairs"`
+}
+
+type BackupAccount struct {
+	Type        string  `json:"type"`
+	PublicKey   string  `json:"public_key"`
+	PrivateKey  string  `json:"private_key,omitempty"`
+	Description string  `json:"description,omitempty"`
+	MemoText    string  `json:"memo_text,omitempty"`
+	MemoId      *uint64 `json:"memo_id,omitempty"`
+}
+
+type BackupAsset struct {
+	Code        string `json:"code"`
+	Issuer      string `json:"issuer,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type BackupTradingPair struct {
+	Asset1      *BackupAsset `json:"asset1"`
+	Asset2      *BackupAsset `json:"asset2"`
+	Description string       `json:"description,omitempty"`
+}
+
+// counts of wallet items added or updated when applying a backup
+type BackupApplyResult struct {
+	accounts, assets, tradingPairs, updated int
+	skipped []string
+}
+
+func backupAccountType(a *stellarwallet.Account) string {
+	switch a.Type() {
+	case stellarwallet.AccountTypeSEP0005:
+		return BackupAccountTypeSEP0005
+	case stellarwallet.AccountTypeRandom:
+		return BackupAccountTypeRandom
+	case stellarwallet.AccountTypeWatching:
+		return BackupAccountTypeWatching
+	case stellarwallet.AccountTypeAddressBook:
+		return BackupAccountTypeAddressBook
+	}
+
+	return "unknown"
+}
+
+func backupAssetRef(a *stellarwallet.Asset) *BackupAsset {
+	if a == nil {
+		return &BackupAsset{Code: "XLM"}
+	}
+
+	return &BackupAsset{Code: a.AssetId(), Issuer: a.Issuer()}
+}
+
+// collects the wallet content, private keys and mnemonic words are included if pw is not nil
+func walletToBackup(w *stellarwallet.Wallet, pw *string) *WalletBackup {
+	b := &WalletBackup{Format: backupFormatPublic, Version: backupVersion, Created: time.Now().UTC()}
+
+	if pw != nil {
+		b.Format = backupFormat
+		b.Wallet = w.ExportBase64()
+		b.Mnemonic = w.Bip39Mnemonic(pw)
+	}
+
+	accounts := append(w.Accounts(), w.AddressBook()...)
+
+	for _, a := range accounts {
+		ba := &BackupAccount{Type: backupAccountType(a), PublicKey: a.PublicKey(), Description: a.Description(),
+			MemoText: a.MemoText()}
+
+		if ok, id := a.MemoId(); ok {
+			ba.MemoId = &id
+		}
+
+		if pw != nil && isSeedAccount(a) {
+			ba.PrivateKey = a.PrivateKey(pw)
+		}
+
+		b.Accounts = append(b.Accounts, ba)
+	}
+
+	for _, a := range w.Assets() {
+		b.Assets = append(b.Assets, &BackupAsset{a.AssetId(), a.Issuer(), a.Description()})
+	}
+
+	for _, tp := range w.TradingPairs() {
+		b.TradingPairs = append(b.TradingPairs, &BackupTradingPair{backupAssetRef(tp.Asset1()),
+			backupAssetRef(tp.Asset2()), tp.Description()})
+	}
+
+	return b
+}
+
+func eraseBackupSecrets(b *WalletBackup) {
+	for _, a := range b.Accounts {
+		stellarwallet.EraseString(&a.PrivateKey)
+	}
+
+	for i := range b.Mnemonic {
+		stellarwallet.EraseString(&b.Mnemonic[i])
+	}
+}
+
+func (b *WalletBackup) check() error {
+	if b.Format != backupFormat && b.Format != backupFormatPublic {
+		return fmt.Errorf("unknown format \"%s\"", b.Format)
+	}
+
+	if b.Version != backupVersion {
+		return fmt.Errorf("unsupported version %d", b.Version)
+	}
+
+	for _, a := range b.Accounts {
+		if !isValidPublicKey(a.PublicKey) {
+			return fmt.Errorf("invalid account public key \"%s\"", a.PublicKey)
+		}
+	}
+
+	return nil
+}
+
+// looks up a wallet asset, nil for native XLM
+// returns false if the asset is not part of the wallet
+func findBackupAsset(w *stellarwallet.Wallet, a *BackupAsset) (*stellarwallet.Asset, bool) {
+	if a == nil || (a.Code == "XLM" && a.Issuer == "") {
+		return nil, true
+	}
+
+	wa := w.FindAsset(a.Issuer, a.Code)
+
+	return wa, wa != nil
+}
+
+// true if the backup account has no memo id or the memo id equals the memo id of the wallet account
+func backupMemoIdMatches(acc *stellarwallet.Account, ba *BackupAccount) bool {
+	if ba.MemoId == nil {
+		return true
+	}
+
+	ok, id := acc.MemoId()
+
+	return ok && id == *ba.MemoId
+}
+
+// adds accounts, assets and trading pairs of the backup that are missing in the wallet and
+// restores descriptions and memos of existing accounts
+// HD (sep0005) accounts cannot be added, they are restored via the mnemonic or account recovery
+func applyBackup(w *stellarwallet.Wallet, b *WalletBackup, pw *string) *BackupApplyResult {
+	res := new(BackupApplyResult)
+
+	for _, ba := range b.Accounts {
+		acc := w.FindAccountByPublicKey(ba.PublicKey)
+
+		if acc == nil {
+			switch ba.Type {
+			case BackupAccountTypeRandom:
+				if ba.PrivateKey != "" {
+					acc = w.AddRandomAccount(&ba.PrivateKey, pw)
+				}
+			case BackupAccountTypeWatching:
+				acc = w.AddWatchingAccount(ba.PublicKey, pw)
+			case BackupAccountTypeAddressBook:
+				acc = w.AddAddressBookAccount(ba.PublicKey, pw)
+			}
+
+			if acc == nil {
+				res.skipped = append(res.skipped, fmt.Sprintf("%s account %s", ba.Type, ba.PublicKey))
+				continue
+			}
+
+			res.accounts++
+		} else if acc.Description() == ba.Description && acc.MemoText() == ba.MemoText && backupMemoIdMatches(acc, ba) {
+			continue
+		} else {
+			res.updated++
+		}
+
+		if ba.Description != "" && acc.Description() != ba.Description {
+			acc.SetDescription(ba.Description, pw)
+		}
+
+		if ba.MemoText != "" && acc.MemoText() != ba.MemoText {
+			acc.SetMemoText(ba.MemoText, pw)
+		}
+
+		if ba.MemoId != nil && !backupMemoIdMatches(acc, ba) {
+			acc.SetMemoId(*ba.MemoId, pw)
+		}
+	}
+
+	for _, ba := range b.Assets {
+		a, ok := findBackupAsset(w, ba)
+
+		if !ok {
+			a = w.AddAsset(ba.Issuer, ba.Code, pw)
+			if a == nil {
+				res.skipped = append(res.skipped, fmt.Sprintf("asset %s/%s", ba.Code, ba.Issuer))
+				continue
+			}
+			res.assets++
+		}
+
+		if a != nil && ba.Description != "" && a.Description() != ba.Description {
+			a.SetDescription(ba.Description, pw)
+		}
+	}
+
+	for _, btp := range b.TradingPairs {
+		a1, ok1 := findBackupAsset(w, btp.Asset1)
+		a2, ok2 := findBackupAsset(w, btp.Asset2)
+
+		if !ok1 || !ok2 {
+			res.skipped = append(res.skipped, "trading pair with unknown asset")
+			continue
+		}
+
+		tp := w.FindTradingPair(a1, a2)
+
+		if tp == nil {
+			tp = w.AddTradingPair(a1, a2, pw)
+			if tp == nil {
+				res.skipped = append(res.skipped, "trading pair " + btp.Description)
+				continue
+			}
+			res.tradingPairs++
+		}
+
+		if btp.Description != "" && tp.Description() != btp.Description {
+			tp.SetDescription(btp.Description, pw)
+		}
+	}
+
+	return res
+}
+
+func printBackupApplyResult(res *BackupApplyResult) {
+	fmt.Printf("Added %d account(s), %d asset(s), %d trading pair(s), updated %d account(s).\n",
+		res.accounts, res.assets, res.tradingPairs, res.updated)
+
+	for _, s := range res.skipped {
+		fmt.Printf("Skipped: %s\n", s)
+	}
+}
+
+func writeBackupFile(fileName string, b *WalletBackup, pw *string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	defer eraseBytes(data)
+
+	if pw == nil {
+		return ioutil.WriteFile(fileName, append(data, '\n'), 0644)
+	}
+
+	s := backupFormatTag + "\n" + encryptWithPassword(data, pw) + "\n"
+
+	return ioutil.WriteFile(fileName, []byte(s), 0600)
+}
+
+// reads an encrypted backup or a public export, getPw() is called for the password of an encrypted backup
+func readBackupFile(fileName string, getPw func(pw *string)) (*WalletBackup, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(string(data), backupFormatTag) {
+		lines := strings.Fields(string(data))
+		if len(lines) != 2 {
+			return nil, errors.New("invalid backup file")
+		}
+
+		var pw string
+		defer stellarwallet.EraseString(&pw)
+
+		getPw(&pw)
+
+		data, err = decryptWithPassword(lines[1], &pw)
+		if err != nil {
+			return nil, err
+		}
+
+		defer eraseBytes(data)
+	}
+
+	b := new(WalletBackup)
+
+	if err = json.Unmarshal(data, b); err != nil {
+		return nil, err
+	}
+
+	if err = b.check(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func enterNewFileName(prompt string) string {
+	fileName := readLine(prompt)
+
+	if fileName == "" {
+		return ""
+	}
+
+	if _, err := os.Stat(fileName); err == nil {
+		if !getOk(fmt.Sprintf("File \"%s\" exists, overwrite", fileName)) {
+			return ""
+		}
+	}
+
+	return fileName
+}
+
+func exportWalletBackup() {
+	fileName := enterNewFileName("Backup file name")
+
+	if fileName == "" {
+		return
+	}
+
+	unlockWallet(false)
+	b := walletToBackup(g_wallet, &g_walletPassword)
+	unlockWalletPassword()
+
+	defer eraseBackupSecrets(b)
+
+	fmt.Println("The backup is encrypted with a backup password, which may differ from the wallet password.")
+
+	var pw string
+	defer stellarwallet.EraseString(&pw)
+
+	getPasswordWithConfirmation("Backup Password", true, &pw)
+
+	if err := writeBackupFile(fileName, b, &pw); err != nil {
+		fmt.Printf("Failed to write backup file \"%s\": %s\n", fileName, err.Error())
+		return
+	}
+
+	fmt.Printf("Wallet backup written to: %s\n", fileName)
+}
+
+func exportWalletPublic() {
+	fileName := enterNewFileName("Export file name")
+
+	if fileName == "" {
+		return
+	}
+
+	if err := writeBackupFile(fileName, walletToBackup(g_wallet, nil), nil); err != nil {
+		fmt.Printf("Failed to write export file \"%s\": %s\n", fileName, err.Error())
+		return
+	}
+
+	fmt.Printf("Public wallet data written to: %s\n", fileName)
+}
+
+func getBackupPassword(pw *string) {
+	getPassword("Backup Password", true, pw)
+}
+
+// merges a backup or public export into the open wallet
+func importWalletBackup() {
+	fileName := readLine("Backup or export file name")
+
+	if fileName == "" {
+		return
+	}
+
+	b, err := readBackupFile(fileName, getBackupPassword)
+	if err != nil {
+		fmt.Printf("Failed to read backup file \"%s\": %s\n", fileName, err.Error())
+		return
+	}
+
+	defer eraseBackupSecrets(b)
+
+	fmt.Printf("Backup created %s: %d account(s), %d asset(s), %d trading pair(s)\n",
+		b.Created.Format(time.RFC3339), len(b.Accounts), len(b.Assets), len(b.TradingPairs))
+
+	if !getOk("Import missing content into wallet") {
+		return
+	}
+
+	unlockWallet(false)
+	res := applyBackup(g_wallet, b, &g_walletPassword)
+	unlockWalletPassword()
+
+	printBackupApplyResult(res)
+
+	if res.accounts+res.assets+res.tradingPairs+res.updated > 0 {
+		saveWallet()
+	}
+}
+
+// restores the wallet file from a backup, called if no wallet file exists
+func restoreWalletFromBackup() {
+	fileName := readLine("Backup file name")
+
+	if fileName == "" {
+		return
+	}
+
+	b, err := readBackupFile(fileName, getBackupPassword)
+	if err != nil {
+		fmt.Printf("Failed to read backup file \"%s\": %s\n", fileName, err.Error())
+		return
+	}
+
+	defer eraseBackupSecrets(b)
+
+	if b.Wallet == "" {
+		fmt.Println("File does not contain a wallet backup.")
+		return
+	}
+
+	w, err := stellarwallet.ImportBase64(b.Wallet)
+	if err != nil {
+		fmt.Printf("Failed to parse wallet: %s\n", err.Error())
+		offerBackupMnemonicRestore(b)
+		return
+	}
+
+	fmt.Println("Enter the wallet password that was valid when the backup was created.")
+
+	var pw string
+	defer stellarwallet.EraseString(&pw)
+
+	for {
+		getPassword("Wallet Password (hit enter to cancel)", false, &pw)
+		if pw == "" || w.CheckPassword(&pw) {
+			break
+		}
+		fmt.Println("Invalid password.")
+	}
+
+	if pw == "" {
+		offerBackupMnemonicRestore(b)
+		return
+	}
+
+	if !w.CheckIntegrity(&pw) {
+		fmt.Println("ATTENTION: Wallet integrity check failed!")
+		offerBackupMnemonicRestore(b)
+		return
+	}
+
+	g_wallet = w
+
+	if saveWalletWithPassword(&pw) {
+		fmt.Printf("Wallet restored to: %s\n", g_walletPath)
+	} else {
+		fmt.Println("Failed to save wallet.")
+	}
+}
+
+func offerBackupMnemonicRestore(b *WalletBackup) {
+	if len(b.Mnemonic) > 0 && getOk("Restore wallet from the mnemonic words of the backup instead") {
+		restoreWalletFromBackupMnemonic(b)
+	}
+}
+
+// derives HD accounts until all given public keys are found
+// returns the number of public keys not found
+func deriveBackupHdAccounts(w *stellarwallet.Wallet, pw *string, pubkeys []string) int {
+	missing := make(map[string]bool)
+	for _, k := range pubkeys {
+		missing[k] = true
+	}
+
+	for i := 0; len(missing) > 0 && i < len(pubkeys)+mnemonicVerifyMaxAccounts; i++ {
+		a := w.GenerateAccount(pw)
+		if a == nil {
+			break
+		}
+		delete(missing, a.PublicKey())
+	}
+
+	return len(missing)
+}
+
+// creates a new wallet from the mnemonic words of a backup, HD accounts are derived from the mnemonic,
+// all other accounts, assets and trading pairs are restored from the backup content
+func restoreWalletFromBackupMnemonic(b *WalletBackup) {
+	var hdAccounts []string
+
+	for _, ba := range b.Accounts {
+		if ba.Type == BackupAccountTypeSEP0005 {
+			hdAccounts = append(hdAccounts, ba.PublicKey)
+		}
+	}
+
+	var pw, wpw string
+
+	defer stellarwallet.EraseString(&pw)
+	defer stellarwallet.EraseString(&wpw)
+
+	fmt.Println("Define new  password for the wallet - this is not the mnemonic password.")
+	getPasswordWithConfirmation("Wallet Password", true, &pw)
+
+	var w *stellarwallet.Wallet
+
+	for {
+		getPassword("Mnemonic Password (hit enter if not set)", false, &wpw)
+
+		w = stellarwallet.NewWalletFromMnemonic(stellarwallet.WalletFlagSignAll, &pw, b.Mnemonic, &wpw)
+		if w == nil {
+			fmt.Println("Invalid mnemonic words in backup.")
+			return
+		}
+
+		if len(hdAccounts) == 0 {
+			if w.GenerateAccount(&pw) == nil {
+				panic("Failed to generate account")
+			}
+			break
+		}
+
+		missing := deriveBackupHdAccounts(w, &pw, hdAccounts)
+		if missing == 0 {
+			break
+		}
+
+		fmt.Printf("%d HD account(s) of the backup cannot be derived, the mnemonic password may be wrong.\n", missing)
+
+		if !getOk("Retry with another mnemonic password") {
+			return
+		}
+	}
+
+	printBackupApplyResult(applyBackup(w, b, &pw))
+
+	g_wallet = w
+
+	if saveWalletWithPassword(&pw) {
+		fmt.Printf("Wallet restored to: %s\n", g_walletPath)
+	} else {
+		fmt.Println("Failed to save wallet.")
+	}
+}
+
+func backupMenu() {
+	menu := []MenuEntryCB{
+		{ exportWalletBackup, "Export Encrypted Wallet Backup", true},
+		{ exportWalletPublic, "Export Public Wallet Data", true},
+		{ importWalletBackup, "Import Backup or Public Export", true}}
+
+	runCallbackMenu(menu, "BACKUP: Select Action", false)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/stellar/go/xdr"
+)
+
+// outgoing amounts of a set of transactions, per asset and per asset/destination
+// path payments are counted with their maximum send amount
+type PaymentSummary struct {
+	assets       map[string]*big.Rat
+	payees       map[string]map[string]*big.Rat // asset -> destination -> amount
+	pathPayments [][]string                     // destination, maximum send amount, received amount
+	merges       [][]string                     // merged account, destination
+	otherOps     map[string]int                 // operation type -> count, operations not listed above
+	ops          int
+}
+
+func newPaymentSummary() *PaymentSummary {
+	return &PaymentSummary{assets: make(map[string]*big.Rat), payees: make(map[string]map[string]*big.Rat),
+		otherOps: make(map[string]int)}
+}
+
+func (ps *PaymentSummary) add(asset, dst string, amnt xdr.Int64) {
+	a := big.NewRat(int64(amnt), 1)
+
+	if ps.assets[asset] == nil {
+		ps.assets[asset] = new(big.Rat)
+		ps.payees[asset] = make(map[string]*big.Rat)
+	}
+
+	ps.assets[asset].Add(ps.assets[asset], a)
+
+	if ps.payees[asset][dst] == nil {
+		ps.payees[asset][dst] = new(big.Rat)
+	}
+
+	ps.payees[asset][dst].Add(ps.payees[asset][dst], a)
+}
+
+func (ps *PaymentSummary) addTransaction(tx *xdr.Transaction) {
+	for _, op := range tx.Operations {
+		ps.ops++
+
+		src := rawPublicKeyToString(tx.SourceAccount)
+		if op.SourceAccount != nil {
+			src = rawPublicKeyToString(*op.SourceAccount)
+		}
+
+		switch op.Body.Type {
+		case xdr.OperationTypeCreateAccount:
+			o := op.Body.CreateAccountOp
+			ps.add("XLM", rawPublicKeyToString(o.Destination), o.StartingBalance)
+
+		case xdr.OperationTypePayment:
+			o := op.Body.PaymentOp
+			ps.add(xdrAssetToString(o.Asset), rawPublicKeyToString(o.Destination), o.Amount)
+
+		case xdr.OperationTypePathPayment:
+			o := op.Body.PathPaymentOp
+			dst := rawPublicKeyToString(o.Destination)
+			ps.add(xdrAssetToString(o.SendAsset), dst, o.SendMax)
+			ps.pathPayments = append(ps.pathPayments, []string{dst,
+				amountToString(big.NewRat(int64(o.SendMax), 1)) + " " + xdrAssetToString(o.SendAsset),
+				amountToString(big.NewRat(int64(o.DestAmount), 1)) + " " + xdrAssetToString(o.DestAsset)})
+
+		case xdr.OperationTypeAccountMerge:
+			ps.merges = append(ps.merges, []string{src, rawPublicKeyToString(*op.Body.Destination)})
+
+		default:
+			opType, _ := opToString(op)
+			ps.otherOps[opType]++
+		}
+	}
+}
+
+func sortedRatKeys(m map[string]*big.Rat) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedIntKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (ps *PaymentSummary) print() {
+	fmt.Printf("Operations: %d\n", ps.ops)
+
+	if len(ps.merges) > 0 {
+		fmt.Println("\nAccount merges, transferring the ENTIRE balance:")
+		table := newCliTable(3)
+		for _, m := range ps.merges {
+			table.appendLine(m[0], "->", m[1])
+		}
+		table.print()
+	}
+
+	if len(ps.otherOps) > 0 {
+		fmt.Println("\nOther operations:")
+		table := newCliTable(2)
+		table.setJustification(CliTableJustificationLeft, CliTableJustificationRight)
+		for _, opType := range sortedIntKeys(ps.otherOps) {
+			table.appendLine(opType, fmt.Sprintf("%d", ps.otherOps[opType]))
+		}
+		table.print()
+	}
+
+	if len(ps.pathPayments) > 0 {
+		fmt.Println("\nPath payments (destination, maximum sent, received):")
+		table := newCliTable(3)
+		table.setJustification(CliTableJustificationLeft, CliTableJustificationRight, CliTableJustificationRight)
+		for _, p := range ps.pathPayments {
+			table.appendLine(p[0], p[1], p[2])
+		}
+		table.print()
+	}
+
+	if len(ps.assets) == 0 {
+		return
+	}
+
+	fmt.Println("\nTotal per asset:")
+	if len(ps.pathPayments) > 0 {
+		fmt.Println("(path payments are included with their maximum send amount)")
+	}
+	table := newCliTable(2)
+	table.setJustification(CliTableJustificationRight)
+	for _, asset := range sortedRatKeys(ps.assets) {
+		table.appendLine(asset, amountToString(ps.assets[asset]))
+	}
+	table.print()
+
+	fmt.Println("\nTotal per asset and destination:")
+	table = newCliTable(3)
+	table.setJustification(CliTableJustificationRight)
+	for _, asset := range sortedRatKeys(ps.assets) {
+		for _, dst := range sortedRatKeys(ps.payees[asset]) {
+			table.appendLine(asset, dst, amountToString(ps.payees[asset][dst]))
+		}
+	}
+	table.print()
+}
+
+// returns transaction files matching given directory (unsigned tx_*.txt files) or glob pattern
+func bulkSignFiles(input string) ([]string, error) {
+	if info, err := os.Stat(input); err == nil && info.IsDir() {
+		return listTransactionFiles(input, false), nil
+	}
+
+	files, err := filepath.Glob(input)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// signs all transaction files of a directory or glob pattern with one confirmation,
+// signed files are written next to the original files
+func bulkSignTransactions() {
+	input := readLine("Directory or glob pattern of transaction files")
+
+	if input == "" {
+		return
+	}
+
+	files, err := bulkSignFiles(input)
+	if err != nil {
+		fmt.Printf("Invalid pattern: %s\n", err.Error())
+		return
+	}
+
+	txfs := loadTransactionFiles(files)
+
+	if len(txfs) == 0 {
+		fmt.Println("No transaction files found.")
+		return
+	}
+
+	fmt.Printf("\nTransaction files (%d):\n", len(txfs))
+	printTransactionFiles(txfs)
+
+	ps := newPaymentSummary()
+	for _, tf := range txfs {
+		ps.addTransaction(&tf.txe.Tx)
+	}
+
+	fmt.Println("\nCombined summary:")
+	ps.print()
+
+	fmt.Println()
+	if !getOk(fmt.Sprintf("Sign all %d transaction(s)", len(txfs))) {
+		return
+	}
+
+	// unlock the wallet once for all transactions
+	if g_wallet != nil {
+		unlockWallet(false)
+		defer unlockWalletPassword()
+	}
+
+	signed := signTransactionFiles(txfs, "")
+
+	fmt.Printf("\nSigned %d of %d transaction(s).\n", len(signed), len(txfs))
+}
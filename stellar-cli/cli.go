@@ -375,7 +375,7 @@ func getMemoHash(prompt string) (hash [32]byte) {
 		if err != nil {
 			fmt.Printf("Invalid hash value (expecting 64 digit hex string): %s\n", err.Error())
 		} else if len(val) != 32 {
-			fmt.Printf("Invalid length of hash value (expecting 64 digits/32 bytes).\n", err.Error())
+			fmt.Printf("Invalid length of hash value (expecting 64 digits/32 bytes).\n")
 		} else {
 			for i := 0; i < 32; i++ {
 				hash[i] = val[i]
@@ -685,6 +685,7 @@ func runMenu(menu []MenuEntry, quitChoice bool) string {
 		}
 
 		if quitChoice && input == "q" {
+			journalSavePending()
 			fmt.Println("Quit.")
 			os.Exit(0)
 		}
@@ -701,7 +702,8 @@ func runMenu(menu []MenuEntry, quitChoice bool) string {
 
 }
 
-func runCallbackMenu(menu []MenuEntryCB, prompt string, loop bool) {
+// returns false if the menu was left with 'q'
+func runCallbackMenu(menu []MenuEntryCB, prompt string, loop bool) bool {
 
 	var table [][]string
 
@@ -739,7 +741,7 @@ func runCallbackMenu(menu []MenuEntryCB, prompt string, loop bool) {
 			}
 
 			if input == "q" {
-				return
+				return false
 			}
 
 			found := false
@@ -748,7 +750,7 @@ func runCallbackMenu(menu []MenuEntryCB, prompt string, loop bool) {
 					found = true
 					menu[i].Callback()
 					if !loop {
-						return
+						return true
 					}
 				}
 			}
@@ -779,7 +781,7 @@ const CliTableJustificationCenter = 2
 func newCliTable(cols int) *CliTable {
 	t := new(CliTable)
 	if cols < 1 {
-		panic(fmt.Sprintf("invalid number of columns: %s", cols))
+		panic(fmt.Sprintf("invalid number of columns: %d", cols))
 	}
 	t.cols = cols
 	t.fp = os.Stdout
@@ -838,7 +840,7 @@ func (t *CliTable)print() {
 					fmt.Fprintf(t.fp, "%s%s", strings.Repeat(" ", n), line[c])
 				case CliTableJustificationCenter:
 					n1 := n/2
-					fmt.Fprintf(t.fp, "%s%s", strings.Repeat(" ", n1), line[c], strings.Repeat(" ", n-n1))
+					fmt.Fprintf(t.fp, "%s%s%s", strings.Repeat(" ", n1), line[c], strings.Repeat(" ", n-n1))
 				default:
 					fmt.Fprintf(t.fp, "%s%s", line[c], strings.Repeat(" ",  n))
 				}
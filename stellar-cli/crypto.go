@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters used for deriving encryption keys from passwords,
+// same cost parameters as used by the wallet
+const (
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+	scryptSaltLength = 16
+	secretboxNonceLength = 24
+)
+
+// generates a random salt for key derivation
+func newSalt() []byte {
+	salt := make([]byte, scryptSaltLength)
+
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		panic(err)
+	}
+
+	return salt
+}
+
+// derives a secretbox key from given password and salt
+func deriveKey(pw *string, salt []byte) *[32]byte {
+	dk, err := scrypt.Key([]byte(*pw), salt, scryptN, scryptR, scryptP, 32)
+
+	if err != nil {
+		panic(err)
+	}
+
+	var key [32]byte
+	copy(key[:], dk)
+	eraseBytes(dk)
+
+	return &key
+}
+
+// encrypts data with given key
+// returns nonce followed by the encrypted data
+func encryptBytes(key *[32]byte, data []byte) []byte {
+	var nonce [secretboxNonceLength]byte
+
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		panic(err)
+	}
+
+	return secretbox.Seal(nonce[:], data, &nonce, key)
+}
+
+// decrypts data encrypted with encryptBytes()
+func decryptBytes(key *[32]byte, data []byte) ([]byte, error) {
+	var nonce [secretboxNonceLength]byte
+
+	if len(data) < secretboxNonceLength+secretbox.Overhead {
+		return nil, errors.New("encrypted data too short")
+	}
+
+	copy(nonce[:], data[:secretboxNonceLength])
+
+	res, ok := secretbox.Open(nil, data[secretboxNonceLength:], &nonce, key)
+
+	if !ok {
+		return nil, errors.New("decryption failed (invalid password?)")
+	}
+
+	return res, nil
+}
+
+// encrypts data with a key derived from given password
+// returns base64 encoded salt, nonce and encrypted data
+func encryptWithPassword(data []byte, pw *string) string {
+	salt := newSalt()
+	key := deriveKey(pw, salt)
+	defer eraseKey(key)
+
+	enc := encryptBytes(key, data)
+
+	return base64.StdEncoding.EncodeToString(append(salt, enc...))
+}
+
+// decrypts data encrypted with encryptWithPassword()
+func decryptWithPassword(s string, pw *string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < scryptSaltLength {
+		return nil, errors.New("encrypted data too short")
+	}
+
+	key := deriveKey(pw, data[:scryptSaltLength])
+	defer eraseKey(key)
+
+	return decryptBytes(key, data[scryptSaltLength:])
+}
+
+func eraseBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func eraseKey(key *[32]byte) {
+	eraseBytes(key[:])
+}
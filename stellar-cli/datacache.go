@@ -21,6 +21,12 @@ type AccountSigner struct {
 	weight uint32
 }
 
+type AccountThresholds struct {
+	low uint32
+	med uint32
+	high uint32
+}
+
 type AccountInfo struct {
 	id string
 	exists bool
@@ -29,11 +35,16 @@ type AccountInfo struct {
 	account *stellarwallet.Account
 	balances map[*Asset]*big.Rat
 	signers []AccountSigner
+	thresholds AccountThresholds
 }
 
 var g_accountInfoCache = make(map[string]*AccountInfo)
 
 func getAccountInfo(id string, timeout time.Duration) *AccountInfo {
+	if !g_online {
+		return nil
+	}
+
 	d := g_accountInfoCache[id]
 
 	if d != nil {
@@ -91,6 +102,9 @@ func getAccountInfo(id string, timeout time.Duration) *AccountInfo {
 		for _, signer := range acc.Signers {
 			d.signers = append(d.signers, AccountSigner{signer.Key, uint32(signer.Weight)})
 		}
+
+		d.thresholds = AccountThresholds{uint32(acc.Thresholds.LowThreshold), uint32(acc.Thresholds.MedThreshold),
+			uint32(acc.Thresholds.HighThreshold)}
 	}
 
 	d.timestamp = time.Now()
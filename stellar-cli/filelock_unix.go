@@ -0,0 +1,40 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// advisory lock on a lock file, based on flock(2)
+type FileLock struct {
+	fp *os.File
+}
+
+// tries to acquire an exclusive lock without blocking, returns false if the lock is held by another process
+func tryLockFile(fileName string) (*FileLock, bool, error) {
+	fp, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = syscall.Flock(int(fp.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+
+	if err == syscall.EWOULDBLOCK {
+		fp.Close()
+		return nil, false, nil
+	}
+
+	if err != nil {
+		fp.Close()
+		return nil, false, err
+	}
+
+	return &FileLock{fp}, true, nil
+}
+
+func (l *FileLock) unlock() {
+	syscall.Flock(int(l.fp.Fd()), syscall.LOCK_UN)
+	l.fp.Close()
+}
@@ -0,0 +1,36 @@
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// lock based on exclusive creation of the lock file, the lock file is removed on unlock
+// a stale lock file left by a crashed process must be removed manually
+type FileLock struct {
+	fileName string
+}
+
+// tries to acquire an exclusive lock without blocking, returns false if the lock is held by another process
+func tryLockFile(fileName string) (*FileLock, bool, error) {
+	fp, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+
+	if os.IsExist(err) {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	fmt.Fprintf(fp, "%d\n", os.Getpid())
+	fp.Close()
+
+	return &FileLock{fileName}, true, nil
+}
+
+func (l *FileLock) unlock() {
+	os.Remove(l.fileName)
+}
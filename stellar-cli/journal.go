@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/stellar/go/build"
+)
+
+// The transaction journal is stored next to the wallet file (<wallet path>.journal).
+// The first line holds a format tag and the base64 encoded scrypt salt, each following line
+// holds one journal entry: the JSON encoded entry encrypted with secretbox using a key
+// derived from the wallet password. Entries are only appended, never modified.
+// Entries recorded while the wallet is locked are kept in memory and written the next time
+// the wallet is unlocked, so that journaling never asks for the wallet password by itself.
+
+const journalFormatTag = "STELLAR-CLI-JOURNAL-1"
+
+const (
+	JournalEventBuilt     = "built"     // unsigned transaction written for later signing
+	JournalEventSigned    = "signed"    // transaction signed with local keys
+	JournalEventSubmitted = "submitted" // transaction successfully submitted
+	JournalEventFailed    = "failed"    // transaction submission failed
+)
+
+type JournalEntry struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Hash    string    `json:"hash"`
+	Network string    `json:"network"`
+	Signers []string  `json:"signers,omitempty"`
+	File    string    `json:"file,omitempty"`
+	Ledger  int32     `json:"ledger,omitempty"`
+	Result  string    `json:"result,omitempty"`
+}
+
+// journal entries recorded while the wallet was locked
+var g_journalPending []*JournalEntry
+
+func journalPath() string {
+	return g_walletPath + ".journal"
+}
+
+// reads the journal salt from the header line, returns nil if the journal does not exist yet
+func journalReadSalt(fileName string) ([]byte, error) {
+	fp, err := os.Open(fileName)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	defer fp.Close()
+
+	scan := bufio.NewScanner(fp)
+
+	if !scan.Scan() {
+		if scan.Err() != nil {
+			return nil, scan.Err()
+		}
+		return nil, nil
+	}
+
+	return parseJournalHeader(scan.Text())
+}
+
+func parseJournalHeader(line string) ([]byte, error) {
+	f := strings.Fields(line)
+
+	if len(f) != 2 || f[0] != journalFormatTag {
+		return nil, errors.New("invalid journal header")
+	}
+
+	return base64.StdEncoding.DecodeString(f[1])
+}
+
+// appends an entry to the journal file, the journal is created if it does not exist
+func journalWrite(e *JournalEntry, pw *string) error {
+	fileName := journalPath()
+
+	salt, err := journalReadSalt(fileName)
+	if err != nil {
+		return err
+	}
+
+	fp, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	if salt == nil {
+		salt = newSalt()
+		_, err = fmt.Fprintf(fp, "%s %s\n", journalFormatTag, base64.StdEncoding.EncodeToString(salt))
+		if err != nil {
+			fp.Close()
+			return err
+		}
+	}
+
+	key := deriveKey(pw, salt)
+	defer eraseKey(key)
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		fp.Close()
+		return err
+	}
+
+	_, err = fmt.Fprintf(fp, "%s\n", base64.StdEncoding.EncodeToString(encryptBytes(key, data)))
+	if err != nil {
+		fp.Close()
+		return err
+	}
+
+	return fp.Close()
+}
+
+// reads and decrypts all journal entries
+func journalRead(fileName string, pw *string) ([]*JournalEntry, error) {
+	fp, err := os.Open(fileName)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	defer fp.Close()
+
+	scan := bufio.NewScanner(fp)
+	scan.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if !scan.Scan() {
+		return nil, scan.Err()
+	}
+
+	salt, err := parseJournalHeader(scan.Text())
+	if err != nil {
+		return nil, err
+	}
+
+	key := deriveKey(pw, salt)
+	defer eraseKey(key)
+
+	var entries []*JournalEntry
+
+	for line := 2; scan.Scan(); line++ {
+		s := strings.TrimSpace(scan.Text())
+		if s == "" {
+			continue
+		}
+
+		enc, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return entries, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+
+		data, err := decryptBytes(key, enc)
+		if err != nil {
+			return entries, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+
+		e := new(JournalEntry)
+		err = json.Unmarshal(data, e)
+		eraseBytes(data)
+		if err != nil {
+			return entries, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, scan.Err()
+}
+
+// re-encrypts the journal with a new password into a temporary file, called on wallet password change
+// before the wallet password is changed, the journal is replaced by journalCommitPassword()
+// returns an empty file name if there is no journal
+func journalChangePassword(pw, newPw *string) (string, error) {
+	fileName := journalPath()
+
+	entries, err := journalRead(fileName, pw)
+	if err != nil {
+		return "", err
+	}
+
+	if entries == nil {
+		return "", nil
+	}
+
+	tmpName := fileName + ".tmp"
+	os.Remove(tmpName)
+
+	salt := newSalt()
+	key := deriveKey(newPw, salt)
+	defer eraseKey(key)
+
+	fp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	w := bufio.NewWriter(fp)
+	fmt.Fprintf(w, "%s %s\n", journalFormatTag, base64.StdEncoding.EncodeToString(salt))
+
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			fp.Close()
+			os.Remove(tmpName)
+			return "", err
+		}
+		fmt.Fprintf(w, "%s\n", base64.StdEncoding.EncodeToString(encryptBytes(key, data)))
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = fp.Sync()
+	}
+	if err != nil {
+		fp.Close()
+		os.Remove(tmpName)
+		return "", err
+	}
+
+	if err = fp.Close(); err != nil {
+		os.Remove(tmpName)
+		return "", err
+	}
+
+	return tmpName, nil
+}
+
+// replaces the journal by the re-encrypted journal written by journalChangePassword()
+func journalCommitPassword(tmpName string) error {
+	if tmpName == "" {
+		return nil
+	}
+
+	return os.Rename(tmpName, journalPath())
+}
+
+// records an event in the transaction journal
+// no-op if no wallet is open
+func journalAppend(e *JournalEntry) {
+	if g_wallet == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if e.Network == "" {
+		e.Network = g_network.Passphrase
+	}
+
+	lockWalletPassword()
+	defer unlockWalletPassword()
+
+	g_journalPending = append(g_journalPending, e)
+
+	if g_walletPassword == "" {
+		fmt.Println("Wallet is locked, transaction journal will be updated when the wallet is unlocked.")
+		return
+	}
+
+	journalWritePending()
+}
+
+// writes the journal entries recorded while the wallet was locked
+// the wallet password must be locked by the caller
+func journalWritePending() {
+	if g_wallet == nil || g_walletPassword == "" {
+		return
+	}
+
+	for len(g_journalPending) > 0 {
+		err := journalWrite(g_journalPending[0], &g_walletPassword)
+
+		if err != nil {
+			fmt.Printf("Failed to write transaction journal \"%s\": %s\n", journalPath(), err.Error())
+			return
+		}
+
+		g_journalPending = g_journalPending[1:]
+	}
+}
+
+// offers to write pending journal entries before the program exits
+func journalSavePending() {
+	if g_wallet == nil || len(g_journalPending) == 0 {
+		return
+	}
+
+	fmt.Printf("%d transaction journal entries are not saved yet, enter wallet password to save them.\n",
+		len(g_journalPending))
+
+	if !unlockWallet(true) {
+		return
+	}
+	defer unlockWalletPassword()
+
+	journalWritePending()
+}
+
+// reads the journal, prompts for the wallet password if required
+func journalLoad() []*JournalEntry {
+	unlockWallet(false)
+	defer unlockWalletPassword()
+
+	journalWritePending()
+
+	entries, err := journalRead(journalPath(), &g_walletPassword)
+
+	if err != nil {
+		fmt.Printf("Failed to read transaction journal \"%s\": %s\n", journalPath(), err.Error())
+	}
+
+	return entries
+}
+
+// returns previous submission attempts of the transaction with given hash
+func journalFindSubmissions(entries []*JournalEntry, hash string) []*JournalEntry {
+	var res []*JournalEntry
+
+	for _, e := range entries {
+		if e.Hash == hash && (e.Event == JournalEventSubmitted || e.Event == JournalEventFailed) {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+// checks the journal for previous submissions of the transaction with given hash
+// returns true if the transaction should be submitted
+func journalCheckDuplicateSubmission(hash string) bool {
+	if g_wallet == nil {
+		return true
+	}
+
+	var entries []*JournalEntry
+
+	lockWalletPassword()
+	defer unlockWalletPassword()
+
+	if g_walletPassword != "" {
+		entries = journalLoad()
+	} else {
+		fmt.Println("Wallet is locked, checking only submissions of the current session.")
+		entries = g_journalPending
+	}
+
+	subs := journalFindSubmissions(entries, hash)
+
+	if len(subs) == 0 {
+		return true
+	}
+
+	fmt.Printf("\nATTENTION: Transaction %s was already submitted:\n", hash)
+	printJournalEntries(subs)
+	fmt.Println()
+
+	return getOk("Submit transaction again")
+}
+
+func networkToString(passphrase string) string {
+	switch passphrase {
+	case build.PublicNetwork.Passphrase:
+		return "public"
+	case build.TestNetwork.Passphrase:
+		return "testnet"
+	}
+
+	return passphrase
+}
+
+func journalEntryResult(e *JournalEntry) string {
+	switch e.Event {
+	case JournalEventSubmitted:
+		return fmt.Sprintf("ledger %d", e.Ledger)
+	case JournalEventFailed:
+		return e.Result
+	case JournalEventBuilt:
+		return e.File
+	case JournalEventSigned:
+		return strings.Join(e.Signers, " ")
+	}
+
+	return ""
+}
+
+func printJournalEntries(entries []*JournalEntry) {
+	table := newCliTable(5)
+
+	for _, e := range entries {
+		table.appendLine(e.Time.Format(time.RFC3339), e.Event, networkToString(e.Network), e.Hash,
+			journalEntryResult(e))
+	}
+
+	table.print()
+}
+
+func printJournalEntry(e *JournalEntry) {
+	var table [][]string
+
+	table = appendTableLine(table, "Time", e.Time.Format(time.RFC3339))
+	table = appendTableLine(table, "Event", e.Event)
+	table = appendTableLine(table, "Hash", e.Hash)
+	table = appendTableLine(table, "Network", e.Network)
+	for _, s := range e.Signers {
+		table = appendTableLine(table, "Signer", s)
+	}
+	if e.File != "" {
+		table = appendTableLine(table, "File", e.File)
+	}
+	if e.Ledger != 0 {
+		table = appendTableLine(table, "Ledger", fmt.Sprintf("%d", e.Ledger))
+	}
+	if e.Result != "" {
+		table = appendTableLine(table, "Result", e.Result)
+	}
+
+	printTable(table, 2, ": ")
+}
+
+func showJournal() {
+	entries := journalLoad()
+
+	if len(entries) == 0 {
+		fmt.Println("Transaction journal is empty.")
+		return
+	}
+
+	fmt.Printf("\nTransaction journal %s:\n", journalPath())
+
+	// show latest entries first
+	for end := len(entries); end > 0; {
+		start := end - 20
+		if start < 0 {
+			start = 0
+		}
+
+		page := make([]*JournalEntry, 0, end-start)
+		for i := end - 1; i >= start; i-- {
+			page = append(page, entries[i])
+		}
+
+		printJournalEntries(page)
+
+		end = start
+
+		if end == 0 || !getOk("\nShow more entries") {
+			break
+		}
+	}
+}
+
+func searchJournal() {
+	hash := strings.ToLower(readLine("Transaction hash (or prefix)"))
+
+	if hash == "" {
+		return
+	}
+
+	found := false
+
+	for _, e := range journalLoad() {
+		if strings.HasPrefix(e.Hash, hash) {
+			fmt.Println()
+			printJournalEntry(e)
+			found = true
+		}
+	}
+
+	if !found {
+		fmt.Println("No matching journal entries.")
+	}
+}
+
+func showDuplicateSubmissions() {
+	entries := journalLoad()
+
+	count := make(map[string]int)
+	var hashes []string
+
+	for _, e := range entries {
+		if e.Event == JournalEventSubmitted || e.Event == JournalEventFailed {
+			if count[e.Hash] == 0 {
+				hashes = append(hashes, e.Hash)
+			}
+			count[e.Hash]++
+		}
+	}
+
+	found := false
+
+	for _, h := range hashes {
+		if count[h] > 1 {
+			fmt.Printf("\nTransaction %s submitted %d times:\n", h, count[h])
+			printJournalEntries(journalFindSubmissions(entries, h))
+			found = true
+		}
+	}
+
+	if !found {
+		fmt.Println("No duplicate submissions found.")
+	}
+}
+
+func journalMenu() {
+	menu := []MenuEntryCB{
+		{ showJournal, "Show Journal", true},
+		{ searchJournal, "Search Journal by Transaction Hash", true},
+		{ showDuplicateSubmissions, "Show Duplicate Submissions", true}}
+
+	runCallbackMenu(menu, "JOURNAL: Select Action", true)
+}
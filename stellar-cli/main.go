@@ -7,7 +7,7 @@ import (
 	"net/http"
 	"io/ioutil"
 	"os"
-	"encoding/hex"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -19,7 +19,6 @@ import (
 	"github.com/stellar/go/clients/stellartoml"
 	"github.com/stellar/go/clients/federation"
 	"github.com/stellar/go/clients/horizon"
-	"github.com/stellar/go/network"
 	"math/big"
 	"sort"
 )
@@ -43,6 +42,14 @@ var (
 	// wallet related global variables
 	g_wallet *stellarwallet.Wallet
 	g_walletPath string
+	g_walletBackupCount int
+	g_walletRegistryPath string
+	g_recoveryGap int
+	g_agentSocket string
+	g_agentPolicy string
+	g_agentIdle int
+	g_agentConfirmKeys stringListFlag
+	g_walletFileHash string // hash of the wallet file when loaded or saved, detects modification by other processes
 	g_walletPassword string
 	g_walletPasswordLock = 0
 	g_walletPasswordLockMutex sync.Mutex
@@ -53,9 +60,16 @@ var (
 	g_txIn = ""
 	g_txOut = ""
 	g_signersFile = ""
+	g_signersKeyFile = ""
 	g_horizonUrl = ""
 	g_testnet = false
 	g_noWallet bool
+	g_offline bool
+	g_inboxDir = ""
+	g_outboxDir = ""
+	g_archiveDir = ""
+	g_qr bool
+	g_sep7 bool
 
 	// settings
 	gReferenceCurrency = ReferenceCurrencyEUR
@@ -70,6 +84,7 @@ func clearSigners() {
 	}
 
 	g_signers = nil
+	g_externalKeys = nil
 }
 
 func setupNetwork() {
@@ -90,12 +105,17 @@ func setupNetwork() {
 	}
 
 	fmt.Println("Using Network       :", g_network)
-	fmt.Println("Using Horizon Server:", g_horizon.URL)
+	if g_online {
+		fmt.Println("Using Horizon Server:", g_horizon.URL)
+	} else {
+		fmt.Println("OFFLINE MODE        : no network access")
+	}
 	fmt.Println()
 }
 
 
-func newKeyPair(pattern string, pos int) {
+// generates a random key pair, optionally with an address containing pattern
+func findKeyPair(pattern string, pos int) *keypair.Full {
 
 	var kp *keypair.Full
 
@@ -139,6 +159,12 @@ func newKeyPair(pattern string, pos int) {
 		}
 	}
 
+	return kp
+}
+
+func newKeyPair(pattern string, pos int) {
+	kp := findKeyPair(pattern, pos)
+
 	fmt.Println("Address    :", kp.Address())
 	fmt.Println("Private Key:", kp.Seed())
 }
@@ -341,34 +367,78 @@ func accountInfo(adr string) {
 func transactionFinalize(acc *stellarwallet.Account, src string, tx *build.TransactionBuilder) {
 	tx_finalize(tx)
 
-	signed, txe := enterSigners(acc, src, tx)
+	var signed bool
+	var txe build.TransactionEnvelopeBuilder
+
+	if acc != nil && isWatchingAccount(acc) {
+		signed, txe = enterWatchingAccountSigners(acc, tx)
+	} else {
+		signed, txe = enterSigners(acc, src, tx, nil)
+	}
 
 	fmt.Println("\nTransaction summary:")
 	
 	print_transaction(txe.E, "", os.Stdout)
 
-	fmt.Println("\n")
+	if len(txe.E.Signatures) > 0 {
+		fmt.Println("\nSignature status:")
+		printSignatureStatus(txe.E, os.Stdout)
+	}
+
+	fmt.Print("\n\n")
 
-	if signed {
+	if signed && !g_online {
+		fmt.Println("OFFLINE: Printing signed transaction for later submission:")
+		outputTransactionBlob(&txe, nil)
+	} else if signed {
 		if getOk("Transmit transaction") {
 			tx_transmit(txe)
 		} else {
 			fmt.Println("Transaction aborted.")
 		}
+	} else if len(txe.E.Signatures) > 0 {
+		fmt.Println("Transaction is not sufficiently signed. Printing partially signed transaction for further signing:")
+		outputTransactionBlob(&txe, enterTransactionNotes())
 	} else {
 		fmt.Println("No signing key provided. Printing unsigned transaction for later signing:")
-		outputTransactionBlob(&txe)
+		outputTransactionBlob(&txe, enterTransactionNotes())
+	}
+}
+
+// watching accounts have no local key, the transaction is only signed with wallet keys
+// that are co-signers of the involved accounts
+// returns true only if the transaction is sufficiently signed
+func enterWatchingAccountSigners(acc *stellarwallet.Account, tx *build.TransactionBuilder) (bool, build.TransactionEnvelopeBuilder) {
+	fmt.Printf("\nNo local key available for watching account %s %s.\n", acc.PublicKey(), acc.Description())
+
+	defer clearSigners()
+
+	accounts := selectWalletSigners(tx.TX, nil)
+
+	if signerCount() > 0 {
+		if !getOk("Sign with co-signer keys of the wallet") {
+			clearSigners()
+		}
+	} else {
+		fmt.Println("Transaction will be written UNSIGNED for offline signing.")
 	}
+
+	signed, txe := tx_sign(tx)
+
+	return signed && !signingWeightMissing(accounts), txe
 }
 
 
 
 func enterSourceAccount() (acc *stellarwallet.Account, src string, tx *build.TransactionBuilder) {
 	for {
-		acc = selectSeedAccount("Select Source Account:", true)
+		acc = selectSourceAccount("Select Source Account:", true)
 
 		if acc != nil {
 			src = acc.PublicKey()
+			if isWatchingAccount(acc) {
+				fmt.Println("Watching account selected - no local key available.")
+			}
 		} else {
 			src = getAddressOrSeed("Source")
 		}
@@ -395,12 +465,21 @@ func enterDestinationAccount(prompt string) string {
 	}
 }
 
-func enterSigners(acc *stellarwallet.Account, key string, tx *build.TransactionBuilder) (bool, build.TransactionEnvelopeBuilder) {
+// collects signing keys: key of the source account, wallet keys that are signers of the transaction
+// source accounts, keys from the signers file and finally keys entered manually if signing weight is missing
+// sigs: signatures already present on the transaction
+func enterSigners(acc *stellarwallet.Account, key string, tx *build.TransactionBuilder,
+	sigs []xdr.DecoratedSignature) (bool, build.TransactionEnvelopeBuilder) {
 
 	if acc != nil {
-		unlockWallet(false)
-		key = acc.PrivateKey(&g_walletPassword)
-		unlockWalletPassword()
+		// keys served by an external signer or the key agent never unlock the local wallet
+		if selectExternalKey(acc.PublicKey()) {
+			fmt.Printf("Using external signer key %s\n", acc.PublicKey())
+		} else {
+			unlockWallet(false)
+			key = acc.PrivateKey(&g_walletPassword)
+			unlockWalletPassword()
+		}
 	} 
 			
 	if key != "" {
@@ -411,13 +490,23 @@ func enterSigners(acc *stellarwallet.Account, key string, tx *build.TransactionB
 		}
 	}
 
-	cnt := readSignersFromFile()
+	defer clearSigners()
 
-	if cnt == 0 {
-		readSigners()
-	}
+	accounts := selectWalletSigners(tx.TX, sigs)
 
-	defer clearSigners()
+	if signingWeightMissing(accounts) {
+		cnt := readSignersFromFile()
+
+		if cnt > 0 {
+			accounts = selectWalletSigners(tx.TX, sigs)
+		}
+
+		if signingWeightMissing(accounts) {
+			fmt.Println("\nMissing signing weight:")
+			printMissingSigningWeight(accounts)
+			readSigners()
+		}
+	}
 
 	return tx_sign(tx)
 }
@@ -490,67 +579,122 @@ func enterMemo(tx *build.TransactionBuilder) {
 	}
 }
 
-func outputTransactionBlob( txe *build.TransactionEnvelopeBuilder) {
-	txeB64, err := txe.Base64()
-	if err != nil {
-		panic(err)
+// returns file name for writing a transaction blob:
+// unsigned transactions are placed in the inbox directory, signed ones in the outbox directory, if configured
+// time stamp of transaction file names, without ':' which is not allowed on FAT file systems
+const txFileTimeFormat = "20060102T150405Z"
+
+func transactionFileName(txe *xdr.TransactionEnvelope) string {
+	date := time.Now().UTC().Format(txFileTimeFormat)
+
+	var prefix, dir string
+	if len(txe.Signatures) == 0 {
+		prefix = "tx"
+		dir = g_inboxDir
+	} else {
+		prefix = "txs"
+		dir = g_outboxDir
 	}
 
-	fmt.Println(txeB64)
+	return filepath.Join(dir, fmt.Sprintf("%s_%s_%s.txt", prefix, date, transactionHashString(&txe.Tx)[0:8]))
+}
 
-	hash, err := network.HashTransaction( &txe.E.Tx, g_network.Passphrase)
+// reads optional notes stored in the transaction file header
+func enterTransactionNotes() []string {
+	note := readLine("Note for transaction file (optional)")
 
+	if note == "" {
+		return nil
+	}
+
+	return []string{note}
+}
+
+// prints the transaction blob and writes it to a transaction file
+// notes: stored in the transaction file header
+func outputTransactionBlob( txe *build.TransactionEnvelopeBuilder, notes []string) {
+	txeB64, err := txe.Base64()
 	if err != nil {
 		panic(err)
 	}
 
-	date := time.Now().Format(time.RFC3339)
+	fmt.Println(txeB64)
 
-	var prefix string
-	if len(txe.E.Signatures) == 0 {
-		prefix = "tx"
-	} else {
-		prefix = "txs"
+	if g_qr {
+		printQrCode(txeB64)
+	}
+
+	if g_sep7 {
+		fmt.Println("\nSEP-7 URI:")
+		fmt.Println(sep7TransactionUri(txeB64))
 	}
 
-	fileName := fmt.Sprintf("%s_%s_%s.txt", prefix, date, hex.EncodeToString(hash[:])[0:8])
+	fileName := transactionFileName(txe.E)
 
-	err = writeTransactionBlob(txeB64, txe.E, fileName)
+	err = writeTransactionBlob(txeB64, txe.E, fileName, notes)
 
 	if err != nil {
 		fmt.Printf("Failed to write transaction blob to file \"%s\": %s\n", fileName, err.Error())
 	} else {
 		fmt.Printf("Transaction blob written to file: %s\n", fileName)
+
+		if len(txe.E.Signatures) == 0 {
+			journalAppend(&JournalEntry{Event: JournalEventBuilt, Hash: transactionHashString(&txe.E.Tx),
+				File: fileName})
+		}
 	}
 
 }
 
 func sign_transaction() {
 	var tx_s string
+	var hdr *TransactionFileHeader
 	var err error
 
 	if g_txIn != "" {
 		fmt.Printf("Reading transaction blob from file: %s\n", g_txIn)
-		tx_s, err = readTransactionBlob(g_txIn)
+		tx_s, hdr, err = readTransactionBlob(g_txIn)
 		if err != nil {
 			fmt.Printf("Failed to open file \"%s\": %s\n", g_txIn, err.Error())
 			return
 		}
 	} else {
-		tx_s = readLine("Transaction blob")
+		tx_s, err = readTransactionBlobInput("Transaction blob, SEP-7 URI or first QR frame")
+		if err != nil {
+			fmt.Printf("Invalid transaction input: %s\n", err.Error())
+			return
+		}
+	}
+
+	tx_s, sep7Req, err := sep7TransactionInput(tx_s)
+	if err != nil {
+		fmt.Printf("Invalid SEP-7 request: %s\n", err.Error())
+		return
 	}
 		
 	txe_xdr := &xdr.TransactionEnvelope{ }
 
-	txe_xdr.Scan(tx_s)
+	if err := txe_xdr.Scan(tx_s); err != nil {
+		fmt.Printf("Invalid transaction blob: %s\n", err.Error())
+		return
+	}
 
-	if txe_xdr.Tx.SourceAccount.Ed25519  == nil {
-		fmt.Printf("Invalid transaction blob: %s\n")
+	if txe_xdr.Tx.SourceAccount.Ed25519 == nil {
+		fmt.Println("Invalid transaction blob: missing source account.")
 		return
 	}
 
 	fmt.Println("\nTransaction details:")
 	print_transaction( txe_xdr, "", os.Stdout )
+	printTransactionFileHeader(hdr)
+
+	if err := hdr.check(txe_xdr); err != nil {
+		fmt.Printf("\nInvalid transaction file: %s\n", err.Error())
+		return
+	}
+
+	fmt.Println("\nSignature status:")
+	printSignatureStatus(txe_xdr, os.Stdout)
 
 	tx, err := build.Transaction(g_network)
 	if err != nil {
@@ -559,44 +703,87 @@ func sign_transaction() {
 		
 	tx.TX = &txe_xdr.Tx
 
-	_, txe := enterSigners(nil, "", tx)
+	_, txe := enterSigners(nil, "", tx, txe_xdr.Signatures)
+
+	// keep signatures already present on the transaction
+	mergeSignatures(txe.E, txe_xdr.Signatures)
+
+	var notes []string
+	if hdr != nil {
+		notes = hdr.notes
+	}
 
 	fmt.Println("\nSigned transaction blob:")	
-	outputTransactionBlob(&txe)
+	outputTransactionBlob(&txe, notes)
+
+	if sep7Req != nil && sep7Req.callback != "" {
+		blob, err := txe.Base64()
+		if err != nil {
+			panic(err)
+		}
+		sep7Callback(sep7Req, blob)
+	}
 	
 }
 
 func submit_transaction() {
 	var tx_s string
+	var hdr *TransactionFileHeader
 	var err error
 
 	if g_txIn != "" {
 		fmt.Printf("Reading transaction blob from file: %s\n", g_txIn)
-		tx_s, err = readTransactionBlob(g_txIn)
+		tx_s, hdr, err = readTransactionBlob(g_txIn)
 		if err != nil {
 			fmt.Printf("Failed to open file \"%s\": %s\n", g_txIn, err.Error())
 			return
 		}
 	} else {
-		tx_s = readLine("Transaction blob")
+		tx_s, err = readTransactionBlobInput("Transaction blob, SEP-7 URI or first QR frame")
+		if err != nil {
+			fmt.Printf("Invalid transaction input: %s\n", err.Error())
+			return
+		}
+	}
+
+	tx_s, _, err = sep7TransactionInput(tx_s)
+	if err != nil {
+		fmt.Printf("Invalid SEP-7 request: %s\n", err.Error())
+		return
 	}
 		
 	txe_xdr := &xdr.TransactionEnvelope{ }
 
-	txe_xdr.Scan(tx_s)
+	if err := txe_xdr.Scan(tx_s); err != nil {
+		fmt.Printf("Invalid transaction blob: %s\n", err.Error())
+		return
+	}
+
 	if txe_xdr.Tx.SourceAccount.Ed25519 == nil {
-		fmt.Printf("Invalid transaction blob: %s\n")
+		fmt.Println("Invalid transaction blob: missing source account.")
 		return
 	}
 
 	fmt.Println("\nTransaction details:")
 	print_transaction( txe_xdr, "", os.Stdout )
+	printTransactionFileHeader(hdr)
+
+	if err := hdr.check(txe_xdr); err != nil {
+		fmt.Printf("\nInvalid transaction file: %s\n", err.Error())
+		return
+	}
 
 	if len(txe_xdr.Signatures) == 0 {
 		fmt.Printf("\nTransaction is not signed - cannot submit.\n")
 		return
 	}
 
+	fmt.Println("\nSignature status:")
+	if !printSignatureStatus(txe_xdr, os.Stdout) {
+		fmt.Println("\nATTENTION: Transaction is not sufficiently signed, submission will most likely fail.")
+	}
+	fmt.Println()
+
 	if getOk("Submit transaction") {
 		tx_transmit_blob(tx_s)
 	}
@@ -685,10 +872,33 @@ func parseCommandLine() {
 	flag.StringVar( &g_txIn, "tx-in", "", "path to file containing a transaction blob")
 	flag.StringVar( &g_txOut, "tx-out", "", "path to file o which a transaction blob is written")
 	flag.StringVar( &g_signersFile, "signers", "", "path to file containing secrect keys for signing transactions")
+	flag.StringVar( &g_signersKeyFile, "signers-key-file", "", "path to key file used instead of a password for an encrypted signers file")
 	flag.StringVar( &g_horizonUrl, "horizon-url", "", "URL to Stellar Horizon server")
 	flag.StringVar( &g_walletPath, "wallet-path", "wallet.dat", "wallet file name")
 	flag.BoolVar( &g_noWallet, "no-wallet", false, "Disable wallet")
+	flag.StringVar( &g_walletRegistryPath, "wallet-registry", "", "wallet registry file (default: ~/.stellar-cli/wallets.json)")
+	flag.IntVar( &g_recoveryGap, "recovery-gap", 5, "number of consecutive unused accounts ending HD account discovery")
+	flag.IntVar( &g_walletBackupCount, "wallet-backups", 5, "number of wallet file backups kept on save")
+	flag.BoolVar( &g_offline, "offline", false, "offline mode for air-gapped signing, no network access")
+	flag.StringVar( &g_inboxDir, "inbox", "", "directory for unsigned transaction files (air-gap transfer)")
+	flag.StringVar( &g_outboxDir, "outbox", "", "directory for signed transaction files (air-gap transfer)")
+	flag.StringVar( &g_archiveDir, "archive", "", "directory for processed transaction files (default: <inbox|outbox>/archive)")
+	flag.BoolVar( &g_qr, "qr", false, "display transaction blobs as QR codes")
+	flag.BoolVar( &g_sep7, "sep7", false, "display SEP-7 URIs (web+stellar:tx) for transaction blobs")
+	flag.Var( &g_externalSignerSpecs, "external-signer", "external signer: exec:<command> [args] or unix:<socket path>, may be repeated")
+	flag.IntVar( &g_externalSignerTimeout, "external-signer-timeout", 120, "seconds to wait for a response of an external signer, 0 waits forever")
+	flag.StringVar( &g_agentSocket, "agent", "", "run as key agent serving signing requests for wallet keys on given Unix socket")
+	flag.StringVar( &g_agentPolicy, "agent-policy", AgentPolicyAuto, "key agent policy: auto or confirm (confirm each signing request)")
+	flag.IntVar( &g_agentIdle, "agent-idle", 300, "key agent idle time in seconds after which the wallet password is erased")
+	flag.Var( &g_agentConfirmKeys, "agent-confirm-key", "public key whose signing requests the key agent always confirms, may be repeated")
 	flag.Parse()
+
+	g_online = !g_offline
+
+	if g_recoveryGap < 1 || g_recoveryGap > 1000 {
+		fmt.Println("Invalid recovery gap, using 5.")
+		g_recoveryGap = 5
+	}
 }
 
 func showTransactions() {
@@ -774,6 +984,7 @@ func transaction() {
 		{ createAccount, "Create New Account", true},
 		{ addTrustLine, "Create Trust Line", true},
 		{ createOrder, "Create Order", true},
+		{ paySep7Request, "Pay SEP-7 Payment Request URI", true},
 		{ setInflationDestination, "Set Inflation Destination", true}}
 
 	
@@ -784,7 +995,7 @@ func transaction() {
 }
 
 func lookupFederation() {
-	fmt.Println("\nLookup Federation Address:\n")
+	fmt.Print("\nLookup Federation Address:\n\n")
 	adr := getFederationAddress("Enter Federation Address")
 	id, memoType, memo := federationLookup(adr)
 	if id != "" {
@@ -867,24 +1078,47 @@ func accountOffers() {
 	}
 }
 
+// the menu is rebuilt after each action as the active wallet may change
 func mainMenu() {
-	menu := []MenuEntryCB{
+	for {
+		if !runCallbackMenu(mainMenuEntries(), mainMenuPrompt(), false) {
+			return
+		}
+	}
+}
+
+func mainMenuPrompt() string {
+	if g_wallet == nil {
+		return "MAIN"
+	}
+
+	return fmt.Sprintf("MAIN [%s]", activeWalletName())
+}
+
+func mainMenuEntries() []MenuEntryCB {
+	return []MenuEntryCB{
 		{ walletMenu, "Wallet Menu", g_wallet != nil },
-		{ showBalances, "Balances", g_wallet != nil },
-		{ showAccountInfo, "Account Info", true },
-		{ accountOffers, "Show Account Offers", true},
-		{ orderBook, "Show Order Book", true},
-		{ trade, "Trading", true},
-		{ showTransactions, "Show Account Transactions", true},
+		{ walletRegistryMenu, "Wallets (Open/Create/Switch)", true },
+		{ showBalances, "Balances", g_wallet != nil && g_online },
+		{ showAccountInfo, "Account Info", g_online },
+		{ accountOffers, "Show Account Offers", g_online},
+		{ orderBook, "Show Order Book", g_online},
+		{ trade, "Trading", g_online},
+		{ showTransactions, "Show Account Transactions", g_online},
 		{ transaction, "Primitive Transactions", true},
-		{ lookupFederation, "Federation Lookup", true},
+		{ txSpecMenu, "Transaction Specifications (YAML)", true},
+		{ lookupFederation, "Federation Lookup", g_online},
+		{ journalMenu, "Transaction Journal", g_wallet != nil},
 		{ generateVanityAddress,  "Generate New Address", true},
+		{ generatePaperWallet, "Generate Paper Wallet", true},
+		{ mnemonicRecoveryAssistant, "Mnemonic Recovery Assistant", true},
 		{ sign_transaction,   "Sign Transaction", true},
-		{ submit_transaction, "Submit Signed Transaction", true},
-		{ fundAccount,  "Fund Account (test network only)", g_testnet} }
-	
-
-	runCallbackMenu(menu, "MAIN", true)
+		{ bulkSignTransactions, "Bulk Sign Transaction Files", true},
+		{ signersFileMenu, "Encrypted Signers File", true},
+		{ merge_transactions, "Merge Transaction Signatures", true},
+		{ submit_transaction, "Submit Signed Transaction", g_online},
+		{ airGapMenu, "Air-Gap Transfer (Inbox/Outbox)", g_inboxDir != "" || g_outboxDir != ""},
+		{ fundAccount,  "Fund Account (test network only)", g_testnet && g_online} }
 }
 
 func walletPasswordResetDaemon() {
@@ -919,17 +1153,32 @@ func main() {
 
 	setupNetwork()
 
+	setupTransferDirectories()
+
+	setupExternalSigners()
+	defer closeExternalSigners()
+
+	go walletPasswordResetDaemon()
+
 	if !g_noWallet {
-		go walletPasswordResetDaemon()
 		openOrCreateWallet()
 	}
 
+	if g_agentSocket != "" {
+		runKeyAgent()
+		return
+	}
+
 	if flag.Arg(0) != "" {
 		kp, err := keypair.Parse(flag.Arg(0))
 		if err != nil {
 			fmt.Println("ERROR: Invalid Address: ", os.Args[1])
 			return
 		}
+		if !g_online {
+			fmt.Println("ERROR: Account info not available in offline mode.")
+			return
+		}
 		accountInfo(kp.Address())
 		return
 	}
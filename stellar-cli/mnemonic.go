@@ -0,0 +1,532 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mua69/stellarwallet"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/stellar/go/exp/crypto/derivation"
+	"github.com/stellar/go/keypair"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// number of word positions asked in the mnemonic backup quiz
+const mnemonicQuizWords = 4
+
+// maximum number of HD accounts derived to check a mnemonic password
+const mnemonicVerifyMaxAccounts = 20
+
+// number of parallel workers deriving and checking recovery candidates
+const mnemonicRecoveryWorkers = 8
+
+const mnemonicWordCount = 24
+
+// retries of requests rejected by Horizon's rate limit, the delay is doubled with each retry
+const (
+	horizonRateLimitRetries = 6
+	horizonRateLimitDelay   = time.Second
+)
+
+// mnemonic satisfying the BIP39 checksum, built from the known words and one candidate word
+type MnemonicCandidate struct {
+	words []string
+	position int
+	accounts []string
+	funded []string
+	err error
+}
+
+func printMnemonicWords(words []string) {
+	var table [][]string
+
+	for i := 0; i < len(words)/6; i++ {
+		table = appendTableLine(table, words[6*i], words[6*i+1], words[6*i+2], words[6*i+3], words[6*i+4], words[6*i+5])
+	}
+
+	printTable(table, 6, "  ")
+}
+
+// returns n distinct random word positions (0 based) in ascending order
+func randomWordPositions(n, count int) []int {
+	perm := make([]int, count)
+	for i := range perm {
+		perm[i] = i
+	}
+
+	for i := count - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			panic(err)
+		}
+		k := int(j.Int64())
+		perm[i], perm[k] = perm[k], perm[i]
+	}
+
+	res := perm[:n]
+	sort.Ints(res)
+
+	return res
+}
+
+// reads a single mnemonic word, returns false if the input is empty
+func readMnemonicWord(prompt string) (string, bool) {
+	for {
+		w := strings.ToLower(strings.TrimSpace(readLine(prompt)))
+
+		if w == "" {
+			return "", false
+		}
+
+		if stellarwallet.CheckMnemonicWord(w) {
+			return w, true
+		}
+
+		fmt.Printf("Invalid mnemonic word: \"%s\"\n", w)
+	}
+}
+
+// asks for randomly chosen words of the mnemonic and the mnemonic password, if set
+// returns verification result and true if the user cancelled the quiz with an empty word
+func mnemonicQuiz(words []string, wpw *string) (bool, bool) {
+	fmt.Println()
+	fmt.Println("Verify your backup of the mnemonic words: enter the words at the requested positions (hit enter to cancel).")
+
+	ok := true
+
+	for _, i := range randomWordPositions(mnemonicQuizWords, len(words)) {
+		w, entered := readMnemonicWord(fmt.Sprintf("Word %d", i+1))
+		if !entered {
+			return false, true
+		}
+
+		if w != words[i] {
+			ok = false
+		}
+
+		stellarwallet.EraseString(&w)
+	}
+
+	if *wpw != "" {
+		var pw string
+		getPassword("Mnemonic Password", false, &pw)
+
+		if pw != *wpw {
+			ok = false
+		}
+
+		stellarwallet.EraseString(&pw)
+	}
+
+	if ok {
+		fmt.Println("Mnemonic backup verified.")
+	} else {
+		fmt.Println("Verification failed: entered words or mnemonic password do not match.")
+	}
+
+	return ok, false
+}
+
+// checks a mnemonic word list and mnemonic password against the wallet seed
+// the words are compared directly, the mnemonic password by deriving HD accounts and
+// looking them up in the wallet
+// returns false if the wallet has no HD accounts and the mnemonic password could not be checked
+func verifyMnemonic(w *stellarwallet.Wallet, pw *string, words []string, wpw *string) (bool, error) {
+	seedWords := w.Bip39Mnemonic(pw)
+
+	defer func() {
+		for i := range seedWords {
+			stellarwallet.EraseString(&seedWords[i])
+		}
+	}()
+
+	if len(seedWords) != len(words) {
+		return false, errors.New("wallet has no mnemonic seed")
+	}
+
+	var wrong []string
+
+	for i := range words {
+		if words[i] != seedWords[i] {
+			wrong = append(wrong, fmt.Sprintf("%d", i+1))
+		}
+	}
+
+	if len(wrong) > 0 {
+		return false, fmt.Errorf("mnemonic words at position(s) %s do not match", strings.Join(wrong, ", "))
+	}
+
+	hdAccounts := make(map[string]bool)
+
+	for _, a := range w.SeedAccounts() {
+		if a.Type() == stellarwallet.AccountTypeSEP0005 {
+			hdAccounts[a.PublicKey()] = true
+		}
+	}
+
+	if len(hdAccounts) == 0 {
+		return false, nil
+	}
+
+	tmp := stellarwallet.NewWalletFromMnemonic(stellarwallet.WalletFlagSignAll, pw, words, wpw)
+	if tmp == nil {
+		return false, errors.New("invalid mnemonic words")
+	}
+
+	for i := 0; i < len(hdAccounts) + mnemonicVerifyMaxAccounts; i++ {
+		a := tmp.GenerateAccount(pw)
+		if a == nil {
+			break
+		}
+
+		if hdAccounts[a.PublicKey()] {
+			return true, nil
+		}
+	}
+
+	return false, errors.New("mnemonic password does not match")
+}
+
+// wallet menu command: checks the user's backup of mnemonic words and mnemonic password
+func verifyMnemonicBackup() {
+	unlockWallet(false)
+	defer unlockWalletPassword()
+
+	fmt.Println("Enter your backup of the 24 mnemonic words:")
+	words := getWordList()
+
+	defer func() {
+		for i := range words {
+			stellarwallet.EraseString(&words[i])
+		}
+	}()
+
+	var wpw string
+	defer stellarwallet.EraseString(&wpw)
+
+	getPassword("Mnemonic Password (hit enter if not set)", false, &wpw)
+
+	pwChecked, err := verifyMnemonic(g_wallet, &g_walletPassword, words, &wpw)
+
+	if err != nil {
+		fmt.Printf("Mnemonic backup verification FAILED: %s\n", err.Error())
+	} else if !pwChecked {
+		fmt.Println("Mnemonic backup PARTIALLY verified: mnemonic words match, but the mnemonic password " +
+			"cannot be checked because the wallet has no HD accounts.")
+	} else {
+		fmt.Println("Mnemonic backup verified.")
+	}
+}
+
+// reads mnemonic words, '?' or a word not in the BIP39 word list marks an unknown word (empty string)
+func getPartialWordList(n int, allowUnknown bool) []string {
+	result := make([]string, 0, n)
+
+	for len(result) < n {
+		s := readLine(fmt.Sprintf("Enter Word %d", len(result)+1))
+
+		for _, w := range splitString(strings.ToLower(strings.TrimSpace(s))) {
+			if len(result) >= n || w == "" {
+				break
+			}
+
+			if w != "?" && !stellarwallet.CheckMnemonicWord(w) {
+				if !allowUnknown {
+					fmt.Printf("Invalid mnemonic word: \"%s\"\n", w)
+					break
+				}
+				fmt.Printf("Word %d \"%s\" is not in the word list, treated as unknown.\n", len(result)+1, w)
+				w = "?"
+			}
+
+			if w == "?" {
+				if !allowUnknown {
+					fmt.Println("Unknown words are not allowed here.")
+					break
+				}
+				w = ""
+			}
+
+			result = append(result, w)
+		}
+	}
+
+	return result
+}
+
+// enumerates all mnemonics satisfying the BIP39 checksum
+// known holds 24 words with one unknown word (empty string), or 23 words if the position
+// of the missing word is unknown
+func mnemonicCandidates(known []string) []*MnemonicCandidate {
+	var res []*MnemonicCandidate
+
+	seen := make(map[string]bool)
+
+	positions := []int{}
+
+	if len(known) == mnemonicWordCount {
+		for i, w := range known {
+			if w == "" {
+				positions = append(positions, i)
+			}
+		}
+	} else {
+		for i := 0; i < mnemonicWordCount; i++ {
+			positions = append(positions, i)
+		}
+	}
+
+	for _, pos := range positions {
+		for _, w := range bip39.GetWordList() {
+			words := make([]string, 0, mnemonicWordCount)
+
+			if len(known) == mnemonicWordCount {
+				words = append(words, known...)
+				words[pos] = w
+			} else {
+				words = append(words, known[:pos]...)
+				words = append(words, w)
+				words = append(words, known[pos:]...)
+			}
+
+			mnemonic := strings.Join(words, " ")
+
+			if seen[mnemonic] {
+				continue
+			}
+
+			if _, err := bip39.EntropyFromMnemonic(mnemonic); err != nil {
+				continue
+			}
+
+			seen[mnemonic] = true
+			res = append(res, &MnemonicCandidate{words: words, position: pos})
+		}
+	}
+
+	return res
+}
+
+// loads an account, requests rejected by Horizon's rate limit (HTTP 429) are retried with increasing delay
+func loadAccountRateLimited(adr string) (*horizon.Account, error) {
+	delay := horizonRateLimitDelay
+
+	for i := 0; ; i++ {
+		acc, err := loadAccount(adr)
+
+		herr, ok := err.(*horizon.Error)
+		if !ok || herr.Problem.Status != http.StatusTooManyRequests || i >= horizonRateLimitRetries {
+			return acc, err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// derives the first n SEP-0005 account addresses of the candidate and checks which ones are funded
+// a failed check is recorded in c.err, the candidate may own funded accounts in this case
+func (c *MnemonicCandidate) check(wpw string, n int) {
+	seed := bip39.NewSeed(strings.Join(c.words, " "), wpw)
+
+	defer stellarwallet.EraseByteBuffer(seed)
+
+	for i := 0; i < n; i++ {
+		key, err := derivation.DeriveForPath(fmt.Sprintf(derivation.StellarAccountPathFormat, i), seed)
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		kp, err := keypair.FromRawSeed(key.RawSeed())
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		c.accounts = append(c.accounts, kp.Address())
+
+		if g_online {
+			acc, err := loadAccountRateLimited(kp.Address())
+			if err != nil {
+				c.err = err
+				return
+			}
+
+			if acc != nil {
+				c.funded = append(c.funded, kp.Address())
+			}
+		}
+	}
+}
+
+// checks all candidates in parallel
+func checkMnemonicCandidates(cands []*MnemonicCandidate, wpw string, n int) {
+	jobs := make(chan *MnemonicCandidate)
+
+	var wg sync.WaitGroup
+
+	var mutex sync.Mutex
+	done := 0
+
+	for i := 0; i < mnemonicRecoveryWorkers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for c := range jobs {
+				c.check(wpw, n)
+
+				mutex.Lock()
+				done++
+				if done%10 == 0 || done == len(cands) {
+					fmt.Printf("\rChecked %d of %d candidates", done, len(cands))
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, c := range cands {
+		jobs <- c
+	}
+
+	close(jobs)
+
+	wg.Wait()
+
+	fmt.Println()
+}
+
+// enumerates mnemonics for a partially known word list and checks which ones own funded accounts
+func mnemonicRecoveryAssistant() {
+	fmt.Println("Mnemonic recovery assistant: finds one missing or misspelled word of a 24 word mnemonic.")
+
+	var known []string
+
+	if getOk("Is the position of the unknown word known") {
+		for {
+			fmt.Println("Enter 24 mnemonic words, enter '?' for the unknown word:")
+			known = getPartialWordList(mnemonicWordCount, true)
+
+			unknown := 0
+			for _, w := range known {
+				if w == "" {
+					unknown++
+				}
+			}
+
+			if unknown == 1 {
+				break
+			}
+
+			fmt.Printf("Exactly one unknown word is required, %d entered.\n", unknown)
+		}
+	} else {
+		fmt.Printf("Enter the %d known mnemonic words in order:\n", mnemonicWordCount-1)
+		known = getPartialWordList(mnemonicWordCount-1, false)
+	}
+
+	var wpw string
+	defer stellarwallet.EraseString(&wpw)
+
+	getPassword("Mnemonic Password (hit enter if not set)", false, &wpw)
+
+	n := 0
+	for n < 1 || n > mnemonicVerifyMaxAccounts {
+		n = getInteger(fmt.Sprintf("Number of accounts to check per candidate (1-%d)", mnemonicVerifyMaxAccounts))
+	}
+
+	cands := mnemonicCandidates(known)
+
+	fmt.Printf("%d candidate(s) satisfy the BIP39 checksum.\n", len(cands))
+
+	if len(cands) == 0 {
+		return
+	}
+
+	if !g_online {
+		fmt.Println("OFFLINE: Funded accounts cannot be checked, derived accounts are listed.")
+	}
+
+	checkMnemonicCandidates(cands, wpw, n)
+
+	// funded candidates first, then candidates that could not be checked
+	sort.SliceStable(cands, func(i, j int) bool {
+		if len(cands[i].funded) != len(cands[j].funded) {
+			return len(cands[i].funded) > len(cands[j].funded)
+		}
+		return cands[i].err != nil && cands[j].err == nil
+	})
+
+	table := newCliTable(5)
+
+	for i, c := range cands {
+		status := ""
+
+		if c.err != nil {
+			status = "error: " + c.err.Error()
+		} else if len(c.funded) > 0 {
+			status = fmt.Sprintf("%d funded", len(c.funded))
+		}
+
+		first := ""
+		if len(c.accounts) > 0 {
+			first = c.accounts[0]
+		}
+
+		table.appendLine(fmt.Sprintf("%d", i+1), fmt.Sprintf("word %d", c.position+1), c.words[c.position], first, status)
+	}
+
+	table.print()
+
+	failed := 0
+	for _, c := range cands {
+		if c.err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("ATTENTION: %d candidate(s) could not be checked completely, they may own funded accounts.\n", failed)
+	}
+
+	for _, c := range cands {
+		for _, a := range c.funded {
+			fmt.Printf("Funded: word %d \"%s\": %s\n", c.position+1, c.words[c.position], a)
+		}
+	}
+
+	if g_wallet != nil {
+		return
+	}
+
+	i := getInteger("Recover wallet with candidate (0 to cancel)")
+
+	if i <= 0 || i > len(cands) {
+		return
+	}
+
+	var pw string
+	defer stellarwallet.EraseString(&pw)
+
+	fmt.Println("Define new  password for the wallet - this is not the mnemonic password.")
+	getPasswordWithConfirmation("Wallet Password", true, &pw)
+
+	g_wallet = stellarwallet.NewWalletFromMnemonic(stellarwallet.WalletFlagSignAll, &pw, cands[i-1].words, &wpw)
+
+	if g_wallet == nil {
+		fmt.Println("Invalid mnemonic words.")
+		return
+	}
+
+	finishWalletRecovery(&pw)
+}
@@ -0,0 +1,183 @@
+// Mock external signer for stellar-cli, reference implementation of the external signer protocol.
+//
+// Usage:
+//   stellar-cli --external-signer "exec:mocksigner -seeds S..."
+//   mocksigner -socket /tmp/signer.sock -seeds S... & stellar-cli --external-signer unix:/tmp/signer.sock
+//
+// Without -seeds a random key is generated, its seed is printed to stderr.
+// -mode reject answers sign requests with an error, -mode corrupt returns invalid signatures,
+// -mode hang never answers sign requests.
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/stellar/go/keypair"
+)
+
+type SignerRequest struct {
+	Id                uint64 `json:"id"`
+	Method            string `json:"method"`
+	PublicKey         string `json:"public_key,omitempty"`
+	NetworkPassphrase string `json:"network_passphrase,omitempty"`
+	Hash              string `json:"hash,omitempty"`
+	Transaction       string `json:"transaction,omitempty"`
+}
+
+type SignerResponse struct {
+	Id         uint64   `json:"id"`
+	Error      string   `json:"error,omitempty"`
+	PublicKeys []string `json:"public_keys,omitempty"`
+	Signature  string   `json:"signature,omitempty"`
+}
+
+var (
+	g_keys = make(map[string]*keypair.Full)
+	g_pubkeys []string
+	g_mode string
+)
+
+func handleRequest(req *SignerRequest) *SignerResponse {
+	resp := &SignerResponse{Id: req.Id}
+
+	switch req.Method {
+	case "public_keys":
+		resp.PublicKeys = g_pubkeys
+
+	case "sign":
+		kp := g_keys[req.PublicKey]
+		if kp == nil {
+			resp.Error = "unknown public key " + req.PublicKey
+			break
+		}
+
+		hash, err := hex.DecodeString(req.Hash)
+		if err != nil || len(hash) != 32 {
+			resp.Error = "invalid hash"
+			break
+		}
+
+		if g_mode == "hang" {
+			select {}
+		}
+
+		if g_mode == "reject" {
+			resp.Error = "signing rejected"
+			break
+		}
+
+		sig, err := kp.Sign(hash)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+
+		if g_mode == "corrupt" {
+			sig[0] ^= 0xff
+		}
+
+		fmt.Fprintf(os.Stderr, "mocksigner: signed %s with %s (%s)\n", req.Hash, req.PublicKey, req.NetworkPassphrase)
+
+		resp.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	default:
+		resp.Error = "unknown method " + req.Method
+	}
+
+	return resp
+}
+
+func serve(r io.Reader, w io.Writer) {
+	scan := bufio.NewScanner(r)
+	scan.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	enc := json.NewEncoder(w)
+
+	for scan.Scan() {
+		var req SignerRequest
+
+		resp := &SignerResponse{}
+
+		if err := json.Unmarshal(scan.Bytes(), &req); err != nil {
+			resp.Error = "invalid request: " + err.Error()
+		} else {
+			resp = handleRequest(&req)
+		}
+
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func main() {
+	var seeds, socket string
+
+	flag.StringVar(&seeds, "seeds", "", "comma separated list of private keys")
+	flag.StringVar(&socket, "socket", "", "listen on given Unix socket instead of stdin/stdout")
+	flag.StringVar(&g_mode, "mode", "ok", "ok, reject (refuse signing), corrupt (return invalid signatures) or hang (never answer sign requests)")
+	flag.Parse()
+
+	if seeds == "" {
+		kp, err := keypair.Random()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(os.Stderr, "mocksigner: generated key %s %s\n", kp.Address(), kp.Seed())
+		seeds = kp.Seed()
+	}
+
+	for _, s := range strings.Split(seeds, ",") {
+		kp, err := keypair.Parse(strings.TrimSpace(s))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mocksigner: invalid seed: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		full, ok := kp.(*keypair.Full)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "mocksigner: not a private key: %s\n", s)
+			os.Exit(1)
+		}
+
+		g_keys[full.Address()] = full
+		g_pubkeys = append(g_pubkeys, full.Address())
+	}
+
+	if socket == "" {
+		serve(os.Stdin, os.Stdout)
+		return
+	}
+
+	os.Remove(socket)
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mocksigner: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mocksigner: %s\n", err.Error())
+			return
+		}
+
+		go func() {
+			serve(conn, conn)
+			conn.Close()
+		}()
+	}
+}
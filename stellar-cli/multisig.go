@@ -0,0 +1,661 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/stellar/go/build"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/xdr"
+)
+
+// maximum number of signatures accepted by the network for a transaction envelope
+const MaxTransactionSignatures = 20
+
+func decodeTransactionBlob(blob string) (*xdr.TransactionEnvelope, error) {
+	txe := &xdr.TransactionEnvelope{}
+
+	err := xdr.SafeUnmarshalBase64(blob, txe)
+	if err != nil {
+		return nil, err
+	}
+
+	if txe.Tx.SourceAccount.Ed25519 == nil {
+		return nil, errors.New("missing source account")
+	}
+
+	return txe, nil
+}
+
+// reads a transaction envelope from given file name or, if no such file exists, decodes input as blob
+// transaction files are checked against the active network, the file header is nil for blob input
+func readTransactionEnvelope(input string) (*xdr.TransactionEnvelope, *TransactionFileHeader, error) {
+	var hdr *TransactionFileHeader
+	blob := input
+
+	if info, err := os.Stat(input); err == nil && !info.IsDir() {
+		blob, hdr, err = readTransactionBlob(input)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	txe, err := decodeTransactionBlob(blob)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = hdr.check(txe); err != nil {
+		return nil, nil, err
+	}
+
+	return txe, hdr, nil
+}
+
+func signaturesEqual(s1, s2 *xdr.DecoratedSignature) bool {
+	return s1.Hint == s2.Hint && bytes.Equal(s1.Signature, s2.Signature)
+}
+
+// adds signatures to the envelope that are not yet present
+// returns number of added signatures
+func mergeSignatures(txe *xdr.TransactionEnvelope, sigs []xdr.DecoratedSignature) int {
+	cnt := 0
+
+	for i := range sigs {
+		found := false
+		for j := range txe.Signatures {
+			if signaturesEqual(&sigs[i], &txe.Signatures[j]) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			txe.Signatures = append(txe.Signatures, sigs[i])
+			cnt++
+		}
+	}
+
+	return cnt
+}
+
+func merge_transactions() {
+	var merged *xdr.TransactionEnvelope
+	var hash string
+
+	fmt.Println("\nMerge signatures of transaction blobs")
+
+	for cnt := 1; ; cnt++ {
+		input := readLine(fmt.Sprintf("Transaction file or blob %d (hit enter when done)", cnt))
+
+		if input == "" {
+			break
+		}
+
+		txe, _, err := readTransactionEnvelope(input)
+
+		if err != nil {
+			fmt.Printf("Invalid transaction: %s\n", err.Error())
+			cnt--
+			continue
+		}
+
+		h := transactionHashString(&txe.Tx)
+
+		if merged == nil {
+			merged = txe
+			hash = h
+			fmt.Printf("Transaction hash: %s, %d signature(s)\n", hash, len(txe.Signatures))
+			continue
+		}
+
+		if h != hash {
+			fmt.Printf("Transaction hash %s does not match %s - skipped.\n", h, hash)
+			cnt--
+			continue
+		}
+
+		added := mergeSignatures(merged, txe.Signatures)
+		fmt.Printf("Added %d of %d signature(s).\n", added, len(txe.Signatures))
+	}
+
+	if merged == nil {
+		return
+	}
+
+	fmt.Println("\nMerged transaction:")
+	print_transaction(merged, "", os.Stdout)
+
+	fmt.Println("\nSignature status:")
+	printSignatureStatus(merged, os.Stdout)
+
+	if len(merged.Signatures) > MaxTransactionSignatures {
+		fmt.Printf("\nATTENTION: Transaction has %d signatures, the network accepts at most %d.\n",
+			len(merged.Signatures), MaxTransactionSignatures)
+	}
+
+	fmt.Println("\nMerged transaction blob:")
+	outputTransactionBlob(&build.TransactionEnvelopeBuilder{E: merged}, nil)
+}
+
+const (
+	ThresholdLow = 0
+	ThresholdMed = 1
+	ThresholdHigh = 2
+)
+
+func thresholdLevelToString(level int) string {
+	switch level {
+	case ThresholdLow:
+		return "low"
+	case ThresholdHigh:
+		return "high"
+	}
+
+	return "medium"
+}
+
+func (t *AccountThresholds) threshold(level int) uint32 {
+	switch level {
+	case ThresholdLow:
+		return t.low
+	case ThresholdHigh:
+		return t.high
+	}
+
+	return t.med
+}
+
+// returns the threshold category an operation requires
+func operationThresholdLevel(op *xdr.Operation) int {
+	switch op.Body.Type {
+	case xdr.OperationTypeAllowTrust, xdr.OperationTypeBumpSequence, xdr.OperationTypeInflation:
+		return ThresholdLow
+
+	case xdr.OperationTypeAccountMerge:
+		return ThresholdHigh
+
+	case xdr.OperationTypeSetOptions:
+		o := op.Body.SetOptionsOp
+		if o.MasterWeight != nil || o.LowThreshold != nil || o.MedThreshold != nil ||
+			o.HighThreshold != nil || o.Signer != nil {
+			return ThresholdHigh
+		}
+	}
+
+	return ThresholdMed
+}
+
+// signing requirement of an account involved in a transaction
+type SourceAccountRequirement struct {
+	id string
+	level int
+}
+
+// returns all source accounts of a transaction and the highest threshold category they need to meet,
+// the transaction source account is always the first entry
+func transactionSourceAccounts(tx *xdr.Transaction) []*SourceAccountRequirement {
+	src := &SourceAccountRequirement{rawPublicKeyToString(tx.SourceAccount), ThresholdLow} // fee and sequence number
+	res := []*SourceAccountRequirement{src}
+
+	for i := range tx.Operations {
+		op := &tx.Operations[i]
+		level := operationThresholdLevel(op)
+
+		id := src.id
+		if op.SourceAccount != nil {
+			id = rawPublicKeyToString(*op.SourceAccount)
+		}
+
+		var req *SourceAccountRequirement
+		for _, r := range res {
+			if r.id == id {
+				req = r
+				break
+			}
+		}
+
+		if req == nil {
+			req = &SourceAccountRequirement{id, level}
+			res = append(res, req)
+		} else if level > req.level {
+			req.level = level
+		}
+	}
+
+	return res
+}
+
+type SignatureCheck struct {
+	sig *xdr.DecoratedSignature
+	signer string // public key of matching signer, empty if not found
+	valid bool
+}
+
+type AccountSignatureStatus struct {
+	id string
+	info *AccountInfo // nil if account could not be loaded
+	level int
+	required uint32
+	weight uint32
+	signers []string // signers with a valid signature
+}
+
+func (s *AccountSignatureStatus) sufficient() bool {
+	return s.info != nil && s.info.exists && s.weight >= s.required
+}
+
+// adds the weight of given signer if it is a signer of the account and was not counted yet
+func (s *AccountSignatureStatus) addSigner(id string) {
+	if s.info == nil || !s.info.exists {
+		return
+	}
+
+	for _, signer := range s.signers {
+		if signer == id {
+			return
+		}
+	}
+
+	for _, signer := range s.info.signers {
+		if signer.id == id && signer.weight > 0 {
+			s.weight += signer.weight
+			s.signers = append(s.signers, id)
+			return
+		}
+	}
+}
+
+// loads thresholds and signers of all source accounts of a transaction
+func transactionSigningStatus(tx *xdr.Transaction) []*AccountSignatureStatus {
+	var accounts []*AccountSignatureStatus
+
+	for _, req := range transactionSourceAccounts(tx) {
+		status := &AccountSignatureStatus{id: req.id, level: req.level}
+		accounts = append(accounts, status)
+
+		status.info = getAccountInfo(req.id, CacheTimeoutShort)
+
+		if status.info != nil && status.info.exists {
+			// a threshold of 0 still requires a signature of weight 1 or higher
+			status.required = status.info.thresholds.threshold(req.level)
+			if status.required == 0 {
+				status.required = 1
+			}
+		}
+	}
+
+	return accounts
+}
+
+// matches each signature to a signer of the involved source accounts via its hint and verifies it
+// against the transaction hash
+func verifySignatures(tx *xdr.Transaction, sigs []xdr.DecoratedSignature,
+	accounts []*AccountSignatureStatus) []*SignatureCheck {
+
+	hash, err := network.HashTransaction(tx, g_network.Passphrase)
+	if err != nil {
+		panic(err)
+	}
+
+	var candidates []string
+
+	for _, a := range accounts {
+		if a.info != nil && a.info.exists {
+			for _, s := range a.info.signers {
+				candidates = append(candidates, s.id)
+			}
+		} else {
+			candidates = append(candidates, a.id)
+		}
+	}
+
+	checks := make([]*SignatureCheck, len(sigs))
+
+	for i := range sigs {
+		sig := &sigs[i]
+		check := &SignatureCheck{sig: sig}
+		checks[i] = check
+
+		for _, c := range candidates {
+			kp, err := keypair.Parse(c)
+			if err != nil {
+				// not an ed25519 key signer (pre-authorized transaction or hash)
+				continue
+			}
+
+			if kp.Hint() != [4]byte(sig.Hint) {
+				continue
+			}
+
+			check.signer = kp.Address()
+
+			if kp.Verify(hash[:], sig.Signature) == nil {
+				check.valid = true
+				break
+			}
+		}
+	}
+
+	return checks
+}
+
+// verifies all signatures of the envelope and sums up the collected weight for each source account
+func checkTransactionSignatures(txe *xdr.TransactionEnvelope) ([]*SignatureCheck, []*AccountSignatureStatus) {
+	accounts := transactionSigningStatus(&txe.Tx)
+	checks := verifySignatures(&txe.Tx, txe.Signatures, accounts)
+
+	for _, a := range accounts {
+		for _, c := range checks {
+			if c.valid {
+				a.addSigner(c.signer)
+			}
+		}
+	}
+
+	return checks, accounts
+}
+
+func signingWeightMissing(accounts []*AccountSignatureStatus) bool {
+	for _, a := range accounts {
+		if !a.sufficient() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns true if given key is a signer of any of the accounts, if the signers of an account are unknown
+// only the account's own key is considered a signer
+func isSignerRelevant(accounts []*AccountSignatureStatus, id string) bool {
+	for _, a := range accounts {
+		if a.info == nil || !a.info.exists {
+			if a.id == id {
+				return true
+			}
+			continue
+		}
+
+		for _, s := range a.info.signers {
+			if s.id == id && s.weight > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isSignerSelected(id string) bool {
+	for _, s := range g_signers {
+		if keypair.MustParse(s).Address() == id {
+			return true
+		}
+	}
+
+	return isExternalKeySelected(id)
+}
+
+// determines the signing weight provided by existing signatures and selected signers (g_signers)
+// and adds keys of external signers and private keys of wallet accounts that are signers of the
+// transaction source accounts until the required weight is reached
+// external signer keys are selected first, the wallet is only unlocked for keys no external signer provides
+func selectWalletSigners(tx *xdr.Transaction, sigs []xdr.DecoratedSignature) []*AccountSignatureStatus {
+	_, accounts := checkTransactionSignatures(&xdr.TransactionEnvelope{Tx: *tx, Signatures: sigs})
+
+	for _, s := range activeSigners() {
+		id := s.PublicKey()
+		for _, a := range accounts {
+			a.addSigner(id)
+		}
+	}
+
+	selectExternalSignerKeys(accounts)
+
+	if g_wallet != nil {
+		selectWalletKeys(accounts)
+	}
+
+	return accounts
+}
+
+// adds private keys of wallet accounts that are signers of the source accounts to g_signers,
+// keys provided by an external signer are skipped
+func selectWalletKeys(accounts []*AccountSignatureStatus) {
+	for _, a := range accounts {
+		if a.info == nil || !a.info.exists {
+			// signers unknown (offline mode), use the account's own key if held by the wallet
+			wa := g_wallet.FindAccountByPublicKey(a.id)
+			if wa != nil && isSeedAccount(wa) && !isSignerSelected(a.id) && findExternalSigner(a.id) == nil &&
+				signerCount() < MaxTransactionSignatures {
+				unlockWallet(false)
+				g_signers = append(g_signers, wa.PrivateKey(&g_walletPassword))
+				unlockWalletPassword()
+
+				fmt.Printf("Using wallet key %s %s\n", wa.PublicKey(), wa.Description())
+			}
+			continue
+		}
+
+		for _, signer := range a.info.signers {
+			if a.sufficient() || signerCount() >= MaxTransactionSignatures {
+				break
+			}
+
+			if signer.weight == 0 || isSignerSelected(signer.id) || findExternalSigner(signer.id) != nil {
+				continue
+			}
+
+			wa := g_wallet.FindAccountByPublicKey(signer.id)
+			if wa == nil || !isSeedAccount(wa) {
+				continue
+			}
+
+			unlockWallet(false)
+			g_signers = append(g_signers, wa.PrivateKey(&g_walletPassword))
+			unlockWalletPassword()
+
+			fmt.Printf("Using wallet key %s %s (weight %d on %s)\n", wa.PublicKey(), wa.Description(),
+				signer.weight, a.id)
+
+			for _, a2 := range accounts {
+				a2.addSigner(signer.id)
+			}
+		}
+	}
+}
+
+// selects keys of external signers that are signers of the source accounts until the required weight is reached
+func selectExternalSignerKeys(accounts []*AccountSignatureStatus) {
+	if len(g_externalSigners) == 0 {
+		return
+	}
+
+	for _, a := range accounts {
+		if a.info == nil || !a.info.exists {
+			// signers unknown (offline mode), use the account's own key if provided by an external signer
+			if !isSignerSelected(a.id) && signerCount() < MaxTransactionSignatures && selectExternalKey(a.id) {
+				fmt.Printf("Using external signer key %s\n", a.id)
+			}
+			continue
+		}
+
+		for _, signer := range a.info.signers {
+			if a.sufficient() || signerCount() >= MaxTransactionSignatures {
+				break
+			}
+
+			if signer.weight == 0 || isSignerSelected(signer.id) || !selectExternalKey(signer.id) {
+				continue
+			}
+
+			fmt.Printf("Using external signer key %s (weight %d on %s)\n", signer.id, signer.weight, a.id)
+
+			for _, a2 := range accounts {
+				a2.addSigner(signer.id)
+			}
+		}
+	}
+}
+
+func printMissingSigningWeight(accounts []*AccountSignatureStatus) {
+	for _, a := range accounts {
+		if a.info == nil || !a.info.exists {
+			fmt.Printf("Account %s: signers unknown\n", a.id)
+		} else if !a.sufficient() {
+			fmt.Printf("Account %s: %d more weight required (%s threshold %d)\n", a.id, a.required-a.weight,
+				thresholdLevelToString(a.level), a.required)
+		}
+	}
+}
+
+// prints signers of all signatures and the collected signature weight of each source account
+// returns true if the transaction is sufficiently signed
+func printSignatureStatus(txe *xdr.TransactionEnvelope, fp io.Writer) bool {
+	checks, accounts := checkTransactionSignatures(txe)
+
+	var table [][]string
+
+	for _, c := range checks {
+		sig := hex.EncodeToString(c.sig.Signature)
+		if len(sig) > 16 {
+			sig = sig[:16] + "..."
+		}
+
+		var status string
+		if c.valid {
+			status = c.signer + " (valid)"
+		} else if c.signer != "" {
+			status = c.signer + " (INVALID SIGNATURE)"
+		} else {
+			status = "unknown signer, hint " + hex.EncodeToString(c.sig.Hint[:])
+		}
+
+		table = appendTableLine(table, "Signature " + sig, status)
+	}
+
+	sufficient := true
+
+	for _, a := range accounts {
+		var status string
+
+		if a.info == nil && !g_online {
+			status = "OFFLINE - signing weight unknown"
+			sufficient = false
+		} else if a.info == nil {
+			status = "account info not available"
+			sufficient = false
+		} else if !a.info.exists {
+			status = "account does not exist"
+			sufficient = false
+		} else {
+			status = fmt.Sprintf("weight %d of %d (%s threshold)", a.weight, a.required,
+				thresholdLevelToString(a.level))
+
+			if a.sufficient() {
+				status += " - sufficiently signed"
+			} else {
+				status += fmt.Sprintf(" - %d more weight required", a.required-a.weight)
+				sufficient = false
+			}
+		}
+
+		table = appendTableLine(table, "Account " + a.id, status)
+	}
+
+	printTablePrefixFp(table, 2, ": ", "", fp)
+
+	return sufficient
+}
+
+// lists accounts that have a seed account of the wallet as signer and offers to add them as watching accounts
+func discoverCoSignedAccounts() {
+	found := false
+
+	for _, a := range g_wallet.SeedAccounts() {
+		fmt.Printf("\nSearching accounts with signer %s %s...\n", a.PublicKey(), a.Description())
+
+		accounts, err := getAccountsForSigner(a.PublicKey())
+		if err != nil {
+			printHorizonError("load accounts for signer", err)
+			continue
+		}
+
+		for i := range accounts {
+			acc := &accounts[i]
+
+			if acc.AccountID == a.PublicKey() {
+				continue
+			}
+
+			var weight int32
+			for _, s := range acc.Signers {
+				if s.Key == a.PublicKey() {
+					weight = s.Weight
+				}
+			}
+
+			if weight == 0 {
+				continue
+			}
+
+			found = true
+
+			thresholds := AccountThresholds{uint32(acc.Thresholds.LowThreshold),
+				uint32(acc.Thresholds.MedThreshold), uint32(acc.Thresholds.HighThreshold)}
+
+			var levels []string
+			for level := ThresholdLow; level <= ThresholdHigh; level++ {
+				if uint32(weight) >= thresholds.threshold(level) {
+					levels = append(levels, thresholdLevelToString(level))
+				}
+			}
+
+			sufficient := "none"
+			if len(levels) > 0 {
+				sufficient = strings.Join(levels, ",")
+			}
+
+			var table [][]string
+			table = appendTableLine(table, "Account", acc.AccountID)
+			table = appendTableLine(table, "Signer Weight", fmt.Sprintf("%d", weight))
+			table = appendTableLine(table, "Thresholds Low/Med/High", fmt.Sprintf("%d/%d/%d", thresholds.low,
+				thresholds.med, thresholds.high))
+			table = appendTableLine(table, "Sufficient Alone For", sufficient)
+			fmt.Println()
+			printTable(table, 2, ": ")
+
+			wa := g_wallet.FindAccountByPublicKey(acc.AccountID)
+			if wa != nil {
+				fmt.Printf("Account already in wallet: %s: %s %s\n", accountTypeToString(wa), wa.PublicKey(),
+					wa.Description())
+				continue
+			}
+
+			if getOk("Add as watching account") {
+				unlockWallet(false)
+				wa = g_wallet.AddWatchingAccount(acc.AccountID, &g_walletPassword)
+				unlockWalletPassword()
+
+				if wa != nil {
+					fmt.Printf("New watching account: %s\n", wa.PublicKey())
+					enterAccountDescription(wa)
+					saveWallet()
+				} else {
+					fmt.Println("Failed to add watching account.")
+				}
+			}
+		}
+	}
+
+	if !found {
+		fmt.Println("\nNo co-signed accounts found.")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/mua69/stellarwallet"
+	"github.com/skip2/go-qrcode"
+	"github.com/stellar/go/keypair"
+)
+
+// Paper wallets are printable sheets holding the address and private key of a new random account,
+// as text and QR code, either as HTML page or plain text.
+// The private key may be encrypted with a passphrase: "SCENC1:" followed by the output of
+// encryptWithPassword() applied to the "S..." seed (base64 encoded scrypt salt, secretbox nonce
+// and encrypted seed). Encrypted keys are imported into the wallet with importPaperWallet().
+
+const paperWalletEncPrefix = "SCENC1:"
+
+type PaperWallet struct {
+	address string
+	seed string // plain or encrypted seed
+	encrypted bool
+	created time.Time
+	network string
+}
+
+func encryptSeed(seed string, pw *string) string {
+	return paperWalletEncPrefix + encryptWithPassword([]byte(seed), pw)
+}
+
+func isEncryptedSeed(s string) bool {
+	return strings.HasPrefix(s, paperWalletEncPrefix)
+}
+
+// decrypts an encrypted seed, returns the "S..." seed
+func decryptSeed(s string, pw *string) (string, error) {
+	if !isEncryptedSeed(s) {
+		return "", errors.New("not an encrypted private key")
+	}
+
+	data, err := decryptWithPassword(s[len(paperWalletEncPrefix):], pw)
+	if err != nil {
+		return "", err
+	}
+
+	seed := string(data)
+	eraseBytes(data)
+
+	kp, err := keypair.Parse(seed)
+	if err != nil {
+		return "", errors.New("decrypted data is not a private key")
+	}
+
+	if _, ok := kp.(*keypair.Full); !ok {
+		return "", errors.New("decrypted data is not a private key")
+	}
+
+	return seed, nil
+}
+
+func qrPngDataUri(data string) (string, error) {
+	png, err := qrcode.Encode(data, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+func (pwl *PaperWallet) seedLabel() string {
+	if pwl.encrypted {
+		return "Encrypted Private Key (passphrase required)"
+	}
+
+	return "Private Key"
+}
+
+func (pwl *PaperWallet) html() (string, error) {
+	adrQr, err := qrPngDataUri(pwl.address)
+	if err != nil {
+		return "", err
+	}
+
+	seedQr, err := qrPngDataUri(pwl.seed)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Stellar Paper Wallet</title>\n")
+	b.WriteString("<style>body { font-family: sans-serif; } .key { font-family: monospace; word-break: break-all; }" +
+		" td { vertical-align: top; padding: 1em; } .cut { border-top: 1px dashed #000; margin: 2em 0; }</style>\n")
+	b.WriteString("</head>\n<body>\n<h1>Stellar Paper Wallet</h1>\n")
+	fmt.Fprintf(&b, "<p>Created %s, %s</p>\n", pwl.created.Format(time.RFC1123), html.EscapeString(pwl.network))
+	b.WriteString("<table>\n<tr>\n")
+	fmt.Fprintf(&b, "<td><h2>Address</h2><img src=\"%s\" alt=\"address\"><p class=\"key\">%s</p></td>\n",
+		adrQr, html.EscapeString(pwl.address))
+	fmt.Fprintf(&b, "<td><h2>%s</h2><img src=\"%s\" alt=\"private key\"><p class=\"key\">%s</p></td>\n",
+		html.EscapeString(pwl.seedLabel()), seedQr, html.EscapeString(pwl.seed))
+	b.WriteString("</tr>\n</table>\n<div class=\"cut\"></div>\n")
+	b.WriteString("<p>Keep the private key secret. Anybody knowing it controls the funds of the account.</p>\n")
+	b.WriteString("</body>\n</html>\n")
+
+	return b.String(), nil
+}
+
+func (pwl *PaperWallet) text() (string, error) {
+	adrQr, err := qrRender(pwl.address)
+	if err != nil {
+		return "", err
+	}
+
+	seedQr, err := qrRender(pwl.seed)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "STELLAR PAPER WALLET\n\nCreated %s, %s\n\n", pwl.created.Format(time.RFC1123), pwl.network)
+	fmt.Fprintf(&b, "Address:\n%s\n\n%s\n", pwl.address, adrQr)
+	fmt.Fprintf(&b, "%s\n\n%s:\n%s\n\n%s\n", strings.Repeat("-", 60), pwl.seedLabel(), pwl.seed, seedQr)
+	b.WriteString("Keep the private key secret. Anybody knowing it controls the funds of the account.\n")
+
+	return b.String(), nil
+}
+
+// generates a new random account and writes a printable sheet
+func generatePaperWallet() {
+	fileName := enterNewFileName("Paper wallet file name (.html for HTML, other for plain text)")
+	if fileName == "" {
+		return
+	}
+
+	kp := findKeyPair("", 0)
+
+	pwl := &PaperWallet{address: kp.Address(), created: time.Now(), network: g_network.Passphrase}
+
+	fmt.Println("Enter an optional passphrase to encrypt the private key on the sheet.")
+
+	var pw string
+	defer stellarwallet.EraseString(&pw)
+
+	getPasswordWithConfirmation("Passphrase", false, &pw)
+
+	if pw != "" {
+		pwl.seed = encryptSeed(kp.Seed(), &pw)
+		pwl.encrypted = true
+	} else {
+		pwl.seed = kp.Seed()
+	}
+
+	var sheet string
+	var err error
+
+	if strings.HasSuffix(strings.ToLower(fileName), ".html") || strings.HasSuffix(strings.ToLower(fileName), ".htm") {
+		sheet, err = pwl.html()
+	} else {
+		sheet, err = pwl.text()
+	}
+
+	if err == nil {
+		err = ioutil.WriteFile(fileName, []byte(sheet), 0600)
+	}
+
+	if err != nil {
+		fmt.Printf("Failed to write paper wallet: %s\n", err.Error())
+		return
+	}
+
+	fmt.Printf("Paper wallet for address %s written to %s.\n", pwl.address, fileName)
+
+	if !pwl.encrypted {
+		fmt.Println("ATTENTION: The file contains the unencrypted private key, delete it after printing.")
+	}
+}
+
+// imports the private key of a paper wallet, plain or encrypted, as random account
+func importPaperWallet() {
+	unlockWallet(false)
+	defer unlockWalletPassword()
+
+	// masked input, plain private keys must not be echoed
+	var input string
+	defer stellarwallet.EraseString(&input)
+
+	getPassword("Private key or encrypted private key ("+paperWalletEncPrefix+"...)", false, &input)
+
+	s := strings.TrimSpace(input)
+
+	if s == "" {
+		return
+	}
+
+	var seed string
+	defer stellarwallet.EraseString(&seed)
+
+	if isEncryptedSeed(s) {
+		var pw string
+		defer stellarwallet.EraseString(&pw)
+
+		getPassword("Passphrase", true, &pw)
+
+		var err error
+
+		seed, err = decryptSeed(s, &pw)
+		if err != nil {
+			fmt.Printf("Failed to decrypt private key: %s\n", err.Error())
+			return
+		}
+	} else {
+		seed = s
+	}
+
+	kp, err := keypair.Parse(seed)
+	if err != nil {
+		fmt.Println("Invalid private key.")
+		return
+	}
+
+	if _, ok := kp.(*keypair.Full); !ok {
+		fmt.Println("Invalid private key.")
+		return
+	}
+
+	if g_wallet.FindAccountByPublicKey(kp.Address()) != nil {
+		fmt.Printf("Account %s is already part of the wallet.\n", kp.Address())
+		return
+	}
+
+	a := g_wallet.AddRandomAccount(&seed, &g_walletPassword)
+
+	if a != nil {
+		fmt.Printf("New account: %s\n", a.PublicKey())
+		enterAccountDescription(a)
+		saveWallet()
+	} else {
+		fmt.Println("Failed to add random account.")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Transaction blobs are displayed as terminal QR codes for transfer to/from an offline signer.
+// Blobs that do not fit into a single QR code are split into numbered frames, each frame
+// holds a part of the blob with the prefix "STX:<id>:<frame>/<total>:", e.g. "STX:1a2b3c4d:2/3:AAAA...".
+// The id is the hex encoded first 4 bytes of the SHA-256 digest of the blob, all frames of a sequence
+// must carry the same id and the reassembled blob must match it.
+// Short blobs are encoded as a single QR code without prefix.
+
+const (
+	qrFramePrefix    = "STX:"
+	qrFrameChunkSize = 300 // blob characters per QR frame, keeps codes readable in a terminal
+	qrMaxFrames      = 256 // upper limit of the frame count accepted from input
+)
+
+// splits data into QR frame payloads
+func qrSplitFrames(data string) []string {
+	if len(data) <= qrFrameChunkSize {
+		return []string{data}
+	}
+
+	n := (len(data) + qrFrameChunkSize - 1) / qrFrameChunkSize
+	frames := make([]string, 0, n)
+	id := qrFrameId(data)
+
+	for i := 0; i < n; i++ {
+		end := (i + 1) * qrFrameChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		frames = append(frames, fmt.Sprintf("%s%s:%d/%d:%s", qrFramePrefix, id, i+1, n, data[i*qrFrameChunkSize:end]))
+	}
+
+	return frames
+}
+
+// returns the id of a frame sequence
+func qrFrameId(data string) string {
+	digest := sha256.Sum256([]byte(data))
+
+	return hex.EncodeToString(digest[:4])
+}
+
+func isQrFrame(s string) bool {
+	return strings.HasPrefix(s, qrFramePrefix)
+}
+
+// parses a QR frame payload
+// returns sequence id, frame number (1 based), total number of frames and frame data
+func qrParseFrame(s string) (id string, frame, total int, data string, err error) {
+	if !isQrFrame(s) {
+		return "", 0, 0, "", errors.New("missing frame prefix")
+	}
+
+	f := strings.SplitN(s[len(qrFramePrefix):], ":", 3)
+	if len(f) != 3 {
+		return "", 0, 0, "", errors.New("invalid frame format")
+	}
+
+	if _, err = hex.DecodeString(f[0]); err != nil || len(f[0]) != 8 {
+		return "", 0, 0, "", errors.New("invalid frame sequence id")
+	}
+
+	n := strings.SplitN(f[1], "/", 2)
+	if len(n) != 2 {
+		return "", 0, 0, "", errors.New("invalid frame number")
+	}
+
+	frame, err = strconv.Atoi(n[0])
+	if err == nil {
+		total, err = strconv.Atoi(n[1])
+	}
+	if err != nil || total < 1 || frame < 1 || frame > total {
+		return "", 0, 0, "", errors.New("invalid frame number")
+	}
+
+	if total > qrMaxFrames {
+		return "", 0, 0, "", fmt.Errorf("too many frames, at most %d are supported", qrMaxFrames)
+	}
+
+	return strings.ToLower(f[0]), frame, total, f[2], nil
+}
+
+// renders data as QR code for terminal output
+func qrRender(data string) (string, error) {
+	q, err := qrcode.New(data, qrcode.Low)
+
+	if err != nil {
+		return "", err
+	}
+
+	return q.ToSmallString(false), nil
+}
+
+// prints data as QR code, split in numbered frames if necessary
+func printQrCode(data string) {
+	frames := qrSplitFrames(data)
+
+	for {
+		for i, f := range frames {
+			s, err := qrRender(f)
+			if err != nil {
+				fmt.Printf("Failed to generate QR code: %s\n", err.Error())
+				return
+			}
+
+			if len(frames) > 1 {
+				fmt.Printf("\nQR frame %d/%d:\n", i+1, len(frames))
+			}
+			fmt.Print(s)
+
+			if i < len(frames)-1 {
+				if readLine("Hit enter for next frame, 'q' to stop") == "q" {
+					return
+				}
+			}
+		}
+
+		if len(frames) == 1 || !getOk("Show frames again") {
+			return
+		}
+	}
+}
+
+// reads the remaining QR frames after the first frame was entered
+// returns the reassembled data
+func readQrFrames(first string) (string, error) {
+	id, frame, total, data, err := qrParseFrame(first)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, total)
+	parts[frame-1] = data
+	missing := total - 1
+
+	for missing > 0 {
+		s := readLine(fmt.Sprintf("QR frame (%d of %d missing, hit enter to cancel)", missing, total))
+
+		if s == "" {
+			return "", errors.New("input cancelled")
+		}
+
+		frameId, frame, n, data, err := qrParseFrame(s)
+		if err != nil {
+			fmt.Printf("Invalid QR frame: %s\n", err.Error())
+			continue
+		}
+
+		if frameId != id {
+			fmt.Printf("Invalid QR frame: frame belongs to sequence %s, expected %s\n", frameId, id)
+			continue
+		}
+
+		if n != total {
+			fmt.Printf("Invalid QR frame: frame belongs to a %d frame sequence, expected %d frames\n", n, total)
+			continue
+		}
+
+		if parts[frame-1] != "" {
+			fmt.Printf("QR frame %d already entered.\n", frame)
+			continue
+		}
+
+		parts[frame-1] = data
+		missing--
+	}
+
+	res := strings.Join(parts, "")
+
+	if qrFrameId(res) != id {
+		return "", errors.New("reassembled data does not match the frame sequence id")
+	}
+
+	return res, nil
+}
+
+// reads a transaction blob from the terminal, either pasted directly or as sequence of scanned QR frames
+func readTransactionBlobInput(prompt string) (string, error) {
+	s := readLine(prompt)
+
+	if isQrFrame(s) {
+		return readQrFrames(s)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mua69/stellarwallet"
+	"github.com/stellar/go/clients/horizon"
+)
+
+// HD account discovery: the wallet library derives SEP-0005 accounts until g_recoveryGap consecutive
+// unused accounts are found. An account counts as used if it exists on the network or if it has an
+// operation history, i.e. it was merged. Discovery runs on a copy of the wallet, discovered accounts
+// are reported and added on confirmation.
+// A network error stops discovery, treating the account as unused could end the search too early.
+
+type DiscoveredAccount struct {
+	address string
+	merged bool
+	balance string
+}
+
+type AccountDiscovery struct {
+	checked int
+	found map[string]*DiscoveredAccount
+	err error // first network error, no further accounts are checked
+}
+
+// returns true if the account has operations on the ledger, also true for merged accounts
+func hasAccountHistory(adr string) (bool, error) {
+	var obj struct {
+		Embedded struct {
+			Records []interface{} `json:"records"`
+		} `json:"_embedded"`
+	}
+
+	url := strings.TrimRight(g_horizon.URL, "/") + "/accounts/" + adr + "/operations?limit=1"
+
+	err := horizonGetJson(url, &obj)
+
+	if err != nil {
+		if herr, ok := err.(*horizon.Error); ok {
+			if herr.Problem.Title == "Resource Missing" {
+				return false, nil
+			}
+		}
+
+		return false, err
+	}
+
+	return len(obj.Embedded.Records) > 0, nil
+}
+
+// fundedCheck callback for RecoverAccounts
+// after an error all accounts are reported as unused so that the library ends the search
+func (d *AccountDiscovery) check(adr string) bool {
+	if d.err != nil {
+		return false
+	}
+
+	d.checked++
+
+	fmt.Printf("\rChecking account %d: %s", d.checked, adr)
+
+	acc, err := loadAccount(adr)
+	if err != nil {
+		d.err = fmt.Errorf("failed to load account %s: %s", adr, err.Error())
+		return false
+	}
+
+	if acc != nil {
+		nb, _ := acc.GetNativeBalance()
+		d.found[adr] = &DiscoveredAccount{address: adr, balance: nb}
+		return true
+	}
+
+	used, err := hasAccountHistory(adr)
+	if err != nil {
+		d.err = fmt.Errorf("failed to load operations of account %s: %s", adr, err.Error())
+		return false
+	}
+
+	if used {
+		d.found[adr] = &DiscoveredAccount{address: adr, merged: true}
+		return true
+	}
+
+	return false
+}
+
+// discovers HD accounts of the wallet, returns the number of added accounts
+func discoverAccounts(pw *string) int {
+	if !g_online {
+		fmt.Println("OFFLINE: Cannot automatically recover accounts.")
+		return 0
+	}
+
+	tmp, err := stellarwallet.ImportBase64(g_wallet.ExportBase64())
+	if err != nil {
+		fmt.Printf("Failed to copy wallet: %s\n", err.Error())
+		return 0
+	}
+
+	fmt.Printf("Discovering accounts, gap limit %d...\n", g_recoveryGap)
+
+	d := &AccountDiscovery{found: make(map[string]*DiscoveredAccount)}
+
+	tmp.RecoverAccounts(pw, uint16(g_recoveryGap), d.check)
+
+	fmt.Println()
+
+	if d.err != nil {
+		fmt.Printf("Account discovery FAILED: %s\n", d.err.Error())
+		fmt.Println("No accounts added, retry account discovery when the network is available.")
+		return 0
+	}
+
+	var added []*stellarwallet.Account
+
+	for _, a := range tmp.Accounts() {
+		if g_wallet.FindAccountByPublicKey(a.PublicKey()) == nil {
+			added = append(added, a)
+		}
+	}
+
+	if len(added) == 0 {
+		fmt.Printf("No new accounts discovered, %d account(s) checked.\n", d.checked)
+		return 0
+	}
+
+	fmt.Printf("Discovered accounts (%d checked):\n", d.checked)
+
+	table := newCliTable(3)
+
+	for _, a := range added {
+		status := "unused"
+
+		if da := d.found[a.PublicKey()]; da != nil {
+			if da.merged {
+				status = "merged"
+			} else {
+				status = "funded"
+			}
+			table.appendLine(a.PublicKey(), status, da.balance)
+		} else {
+			table.appendLine(a.PublicKey(), status, "")
+		}
+	}
+
+	table.print()
+
+	if !getOk(fmt.Sprintf("Add %d discovered account(s) to wallet", len(added))) {
+		return 0
+	}
+
+	g_wallet = tmp
+
+	return len(added)
+}
+
+// account menu command: discovers HD accounts of an existing wallet
+func discoverWalletAccounts() {
+	unlockWallet(false)
+	defer unlockWalletPassword()
+
+	if discoverAccounts(&g_walletPassword) > 0 {
+		saveWallet()
+	}
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mua69/stellarwallet"
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/build"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/xdr"
+)
+
+// SEP-7 URI scheme: https://github.com/stellar/stellar-protocol/blob/master/ecosystem/sep-0007.md
+// Transaction requests have the form "web+stellar:tx?xdr=...", payment requests the form
+// "web+stellar:pay?destination=...". Requests with origin_domain must carry a signature created
+// with the URI_REQUEST_SIGNING_KEY published in the stellar.toml of the origin domain.
+
+const (
+	sep7Scheme           = "web+stellar:"
+	sep7OpTx             = "tx"
+	sep7OpPay            = "pay"
+	sep7MaxMsgLength     = 300
+	sep7SignaturePrefix  = "stellar.sep.7 - URI Scheme"
+	sep7SignatureParam   = "&signature="
+	sep7TomlFetchTimeout = 10 * time.Second
+)
+
+type Sep7Request struct {
+	uri       string
+	operation string
+	params    url.Values
+
+	// transaction request
+	txe    *xdr.TransactionEnvelope
+	pubkey string
+
+	// payment request
+	destination string
+	amount      *big.Rat
+	asset       *Asset
+	memoType    string
+	memo        string
+
+	callback     string
+	msg          string
+	originDomain string
+	verified     bool // origin domain signature verified
+}
+
+func isSep7Uri(s string) bool {
+	return strings.HasPrefix(s, sep7Scheme)
+}
+
+// parses and validates a SEP-7 URI
+// the origin domain signature is verified if the origin_domain parameter is present and network access is available
+func parseSep7Uri(uri string) (*Sep7Request, error) {
+	if !isSep7Uri(uri) {
+		return nil, errors.New("not a SEP-7 URI")
+	}
+
+	f := strings.SplitN(uri[len(sep7Scheme):], "?", 2)
+	if len(f) != 2 {
+		return nil, errors.New("missing parameters")
+	}
+
+	params, err := url.ParseQuery(f[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameters: %s", err.Error())
+	}
+
+	for k, v := range params {
+		if len(v) > 1 {
+			return nil, fmt.Errorf("duplicate parameter: %s", k)
+		}
+	}
+
+	req := &Sep7Request{uri: uri, operation: f[0], params: params}
+
+	switch req.operation {
+	case sep7OpTx:
+		err = req.parseTx()
+	case sep7OpPay:
+		err = req.parsePay()
+	default:
+		err = fmt.Errorf("unsupported operation: %s", req.operation)
+	}
+
+	if err == nil {
+		err = req.parseCommon()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (req *Sep7Request) parseTx() error {
+	blob := req.params.Get("xdr")
+	if blob == "" {
+		return errors.New("missing parameter: xdr")
+	}
+
+	txe, err := decodeTransactionBlob(blob)
+	if err != nil {
+		return fmt.Errorf("invalid transaction: %s", err.Error())
+	}
+	req.txe = txe
+
+	if req.params.Get("replace") != "" {
+		return errors.New("parameter replace is not supported")
+	}
+
+	if req.params.Get("chain") != "" {
+		return errors.New("parameter chain is not supported")
+	}
+
+	req.pubkey = req.params.Get("pubkey")
+	if req.pubkey != "" && !isValidPublicKey(req.pubkey) {
+		return errors.New("invalid parameter: pubkey")
+	}
+
+	return nil
+}
+
+func (req *Sep7Request) parsePay() error {
+	req.destination = req.params.Get("destination")
+	if !isValidPublicKey(req.destination) {
+		return errors.New("invalid or missing parameter: destination")
+	}
+
+	if a := req.params.Get("amount"); a != "" {
+		amnt, err := amount.Parse(a)
+		if err != nil || amnt <= 0 {
+			return errors.New("invalid parameter: amount")
+		}
+		req.amount = big.NewRat(int64(amnt), 1)
+	}
+
+	code := req.params.Get("asset_code")
+	issuer := req.params.Get("asset_issuer")
+
+	if code == "" || (code == "XLM" && issuer == "") {
+		if issuer != "" {
+			return errors.New("parameter asset_issuer without asset_code")
+		}
+		req.asset = newNativeAsset()
+	} else {
+		if err := stellarwallet.CheckAssetId(code); err != nil {
+			return fmt.Errorf("invalid parameter asset_code: %s", err.Error())
+		}
+		if !isValidPublicKey(issuer) {
+			return errors.New("invalid or missing parameter: asset_issuer")
+		}
+		req.asset = newAsset(issuer, code)
+	}
+
+	req.memo = req.params.Get("memo")
+	req.memoType = req.params.Get("memo_type")
+
+	if req.memo == "" {
+		if req.memoType != "" {
+			return errors.New("parameter memo_type without memo")
+		}
+		return nil
+	}
+
+	if req.memoType == "" {
+		req.memoType = "MEMO_TEXT"
+	}
+
+	switch req.memoType {
+	case "MEMO_TEXT":
+		if len(req.memo) > 28 {
+			return errors.New("memo text too long")
+		}
+	case "MEMO_ID":
+		if _, err := strconv.ParseUint(req.memo, 10, 64); err != nil {
+			return errors.New("invalid memo id")
+		}
+	case "MEMO_HASH", "MEMO_RETURN":
+		if _, err := decodeSep7MemoHash(req.memo); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid parameter memo_type: %s", req.memoType)
+	}
+
+	return nil
+}
+
+func (req *Sep7Request) parseCommon() error {
+	passphrase := req.params.Get("network_passphrase")
+	if passphrase == "" {
+		passphrase = build.PublicNetwork.Passphrase
+	}
+
+	if passphrase != g_network.Passphrase {
+		return fmt.Errorf("request is for network \"%s\", active network is \"%s\"", passphrase,
+			g_network.Passphrase)
+	}
+
+	req.msg = req.params.Get("msg")
+	if len(req.msg) > sep7MaxMsgLength {
+		return errors.New("parameter msg too long")
+	}
+
+	if cb := req.params.Get("callback"); cb != "" {
+		if !strings.HasPrefix(cb, "url:") {
+			return errors.New("invalid parameter: callback")
+		}
+		req.callback = cb[len("url:"):]
+		u, err := url.Parse(req.callback)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return errors.New("invalid parameter: callback")
+		}
+	}
+
+	req.originDomain = req.params.Get("origin_domain")
+	sig := req.params.Get("signature")
+
+	if req.originDomain == "" {
+		if sig != "" {
+			return errors.New("parameter signature without origin_domain")
+		}
+		return nil
+	}
+
+	if sig == "" {
+		return errors.New("parameter origin_domain requires a signature")
+	}
+
+	if strings.ContainsAny(req.originDomain, "/:@ ") || !strings.Contains(req.originDomain, ".") {
+		return errors.New("invalid parameter: origin_domain")
+	}
+
+	if !g_online {
+		return nil
+	}
+
+	return req.verifySignature(sig)
+}
+
+// verifies the request signature with the URI request signing key of the origin domain
+func (req *Sep7Request) verifySignature(sig string) error {
+	i := strings.LastIndex(req.uri, sep7SignatureParam)
+	if i < 0 {
+		return errors.New("signature must be the last parameter")
+	}
+
+	sigBytes, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return errors.New("invalid signature encoding")
+	}
+
+	key, err := fetchUriRequestSigningKey(req.originDomain)
+	if err != nil {
+		return fmt.Errorf("failed to get URI request signing key of %s: %s", req.originDomain, err.Error())
+	}
+
+	kp, err := keypair.Parse(key)
+	if err != nil {
+		return fmt.Errorf("invalid URI request signing key of %s", req.originDomain)
+	}
+
+	if kp.Verify(sep7SignaturePayload(req.uri[:i]), sigBytes) != nil {
+		return fmt.Errorf("signature does not match URI request signing key of %s", req.originDomain)
+	}
+
+	req.verified = true
+
+	return nil
+}
+
+// payload signed for SEP-7 URIs: 35 zero bytes, byte 4, prefix string and the URI without signature
+func sep7SignaturePayload(uri string) []byte {
+	payload := make([]byte, 36)
+	payload[35] = 4
+
+	payload = append(payload, []byte(sep7SignaturePrefix)...)
+
+	return append(payload, []byte(uri)...)
+}
+
+func fetchUriRequestSigningKey(domain string) (string, error) {
+	client := &http.Client{Timeout: sep7TomlFetchTimeout}
+
+	resp, err := client.Get("https://" + domain + "/.well-known/stellar.toml")
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("stellar.toml: http status %d", resp.StatusCode)
+	}
+
+	var st struct {
+		UriRequestSigningKey string `toml:"URI_REQUEST_SIGNING_KEY"`
+	}
+
+	if _, err := toml.DecodeReader(resp.Body, &st); err != nil {
+		return "", err
+	}
+
+	if st.UriRequestSigningKey == "" {
+		return "", errors.New("URI_REQUEST_SIGNING_KEY not defined")
+	}
+
+	return st.UriRequestSigningKey, nil
+}
+
+// checks for a valid public key (G...)
+func isValidPublicKey(s string) bool {
+	kp, err := keypair.Parse(s)
+
+	if err != nil {
+		return false
+	}
+
+	_, isFull := kp.(*keypair.Full)
+
+	return !isFull
+}
+
+func decodeSep7MemoHash(s string) (hash [32]byte, err error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		return hash, errors.New("invalid memo hash")
+	}
+
+	copy(hash[:], b)
+
+	return hash, nil
+}
+
+func (req *Sep7Request) originToString() string {
+	if req.originDomain == "" {
+		return "none"
+	}
+
+	if req.verified {
+		return req.originDomain + " (signature verified)"
+	}
+
+	return req.originDomain + " (NOT VERIFIED - offline)"
+}
+
+func printSep7Request(req *Sep7Request) {
+	var table [][]string
+
+	table = appendTableLine(table, "Request", req.operation)
+	table = appendTableLine(table, "Origin Domain", req.originToString())
+
+	if req.operation == sep7OpPay {
+		table = appendTableLine(table, "Destination", req.destination)
+		table = appendTableLine(table, "Asset", req.asset.StringPretty())
+		if req.amount != nil {
+			table = appendTableLine(table, "Amount", amountToString(req.amount))
+		} else {
+			table = appendTableLine(table, "Amount", "not specified")
+		}
+		if req.memo != "" {
+			table = appendTableLine(table, req.memoType, req.memo)
+		}
+	}
+
+	if req.pubkey != "" {
+		table = appendTableLine(table, "Requested Signer", req.pubkey)
+	}
+
+	if req.callback != "" {
+		table = appendTableLine(table, "Callback", req.callback)
+	}
+
+	if req.msg != "" {
+		table = appendTableLine(table, "Message", req.msg)
+	}
+
+	printTable(table, 2, ": ")
+}
+
+// sends a signed transaction to the callback URL of a transaction request
+func sep7Callback(req *Sep7Request, blob string) {
+	fmt.Printf("\nThe request asks to send the signed transaction to %s instead of submitting it to the network.\n",
+		req.callback)
+
+	if !g_online {
+		fmt.Println("OFFLINE: Cannot send transaction to callback URL.")
+		return
+	}
+
+	if !getOk("Send signed transaction to callback URL") {
+		return
+	}
+
+	resp, err := http.PostForm(req.callback, url.Values{"xdr": {blob}})
+	if err != nil {
+		fmt.Printf("Failed to send transaction: %s\n", err.Error())
+		return
+	}
+
+	resp.Body.Close()
+
+	fmt.Printf("Callback response: %s\n", resp.Status)
+}
+
+// returns a SEP-7 transaction request URI for given transaction blob
+func sep7TransactionUri(blob string) string {
+	params := url.Values{}
+	params.Set("xdr", blob)
+
+	if g_network.Passphrase != build.PublicNetwork.Passphrase {
+		params.Set("network_passphrase", g_network.Passphrase)
+	}
+
+	return sep7Scheme + sep7OpTx + "?" + params.Encode()
+}
+
+// returns a SEP-7 payment request URI
+func sep7PaymentUri(dst string, asset *Asset, amnt *big.Rat, memoType, memo, msg string) string {
+	params := url.Values{}
+	params.Set("destination", dst)
+
+	if amnt != nil {
+		params.Set("amount", amountToString(amnt))
+	}
+
+	if !asset.isNative() {
+		params.Set("asset_code", asset.Code())
+		params.Set("asset_issuer", asset.Issuer())
+	}
+
+	if memo != "" {
+		params.Set("memo", memo)
+		params.Set("memo_type", memoType)
+	}
+
+	if msg != "" {
+		params.Set("msg", msg)
+	}
+
+	if g_network.Passphrase != build.PublicNetwork.Passphrase {
+		params.Set("network_passphrase", g_network.Passphrase)
+	}
+
+	return sep7Scheme + sep7OpPay + "?" + params.Encode()
+}
+
+// creates a payment request URI for a wallet account
+func generatePaymentRequest() {
+	acc := selectAccount("Receiving Account", false, g_wallet.Accounts())
+
+	if acc == nil {
+		return
+	}
+
+	asset := enterAsset("Requested")
+
+	var amnt *big.Rat
+	if getOk("Specify amount") {
+		amnt = getAmount("Amount")
+	}
+
+	memoType, memo := "", ""
+	if m := acc.MemoText(); m != "" {
+		memoType, memo = "MEMO_TEXT", m
+	} else if ok, id := acc.MemoId(); ok {
+		memoType, memo = "MEMO_ID", strconv.FormatUint(id, 10)
+	}
+
+	msg := readLine("Message (optional)")
+	if len(msg) > sep7MaxMsgLength {
+		msg = msg[:sep7MaxMsgLength]
+	}
+
+	uri := sep7PaymentUri(acc.PublicKey(), asset, amnt, memoType, memo, msg)
+
+	fmt.Println("\nPayment request URI:")
+	fmt.Println(uri)
+
+	if g_qr {
+		printQrCode(uri)
+	}
+}
+
+// builds a payment transaction from a pasted SEP-7 payment request URI
+func paySep7Request() {
+	uri := readLine("Payment request URI (web+stellar:pay?...)")
+
+	if uri == "" {
+		return
+	}
+
+	req, err := parseSep7Uri(uri)
+	if err != nil {
+		fmt.Printf("Invalid payment request: %s\n", err.Error())
+		return
+	}
+
+	if req.operation != sep7OpPay {
+		fmt.Println("Not a payment request.")
+		return
+	}
+
+	fmt.Println("\nPayment request:")
+	printSep7Request(req)
+	fmt.Println()
+
+	if !getOk("Pay request") {
+		return
+	}
+
+	acc, src, tx := enterSourceAccount()
+
+	amnt := req.amount
+	if amnt == nil {
+		amnt = getAmount(req.asset.codeToString())
+	}
+
+	tx_payment_asset(tx, req.destination, req.asset, amnt)
+
+	switch req.memoType {
+	case "MEMO_TEXT":
+		tx_memoText(tx, req.memo)
+	case "MEMO_ID":
+		id, _ := strconv.ParseUint(req.memo, 10, 64)
+		tx_memoID(tx, id)
+	case "MEMO_HASH":
+		hash, _ := decodeSep7MemoHash(req.memo)
+		tx_memoHash(tx, hash)
+	case "MEMO_RETURN":
+		hash, _ := decodeSep7MemoHash(req.memo)
+		tx_memoRetHash(tx, hash)
+	}
+
+	if req.callback != "" {
+		fmt.Printf("Note: callback %s is ignored for payment requests, transaction is submitted to the network.\n",
+			req.callback)
+	}
+
+	transactionFinalize(acc, src, tx)
+}
+
+// extracts the transaction blob if input is a SEP-7 transaction request URI, other input is returned unchanged
+// returns the parsed request or nil if input is not a SEP-7 URI
+func sep7TransactionInput(input string) (string, *Sep7Request, error) {
+	if !isSep7Uri(input) {
+		return input, nil, nil
+	}
+
+	req, err := parseSep7Uri(input)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if req.operation != sep7OpTx {
+		return "", nil, errors.New("not a transaction request")
+	}
+
+	fmt.Println("\nSEP-7 transaction request:")
+	printSep7Request(req)
+
+	return req.params.Get("xdr"), req, nil
+}
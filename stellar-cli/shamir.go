@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mua69/stellarwallet"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// Shamir secret sharing of the wallet mnemonic:
+// The secret is the BIP39 entropy of the 24 mnemonic words (32 bytes). Each byte is shared separately
+// with a polynomial of degree M-1 over GF(256) (reduction polynomial x^8+x^4+x^3+x+1, as used by AES).
+// As in SLIP-0039 the polynomial is defined by M points: the secret at x=255, a digest share at x=254 and
+// M-2 random shares at x=1..M-2, the remaining shares are interpolated. Share x (1..N) holds the
+// polynomial values at x.
+// The digest share consists of the first 4 bytes of HMAC-SHA256(R, secret) followed by 28 random bytes R.
+// Recovery interpolates the secret and the digest share from any M shares and checks the digest, which
+// detects wrong shares, mistyped share numbers and shares of different splits. The digest is never
+// revealed by fewer than M shares.
+//
+// A share is written as a header line "<set id>-<M>-<x>" followed by its 32 bytes encoded as 24 BIP39 words,
+// the BIP39 checksum detects typing errors. The set id is random for each split and identifies its shares.
+// The mnemonic password, if used, is not part of the shares.
+
+const (
+	shamirMaxShares    = 16
+	shamirSecretIndex  = 255
+	shamirDigestIndex  = 254
+	shamirDigestLength = 4
+)
+
+type MnemonicShare struct {
+	set string
+	threshold int
+	index int
+	words []string
+}
+
+var g_gf256Exp [510]byte
+var g_gf256Log [256]byte
+
+func init() {
+	x := byte(1)
+
+	for i := 0; i < 255; i++ {
+		g_gf256Exp[i] = x
+		g_gf256Exp[i+255] = x
+		g_gf256Log[x] = byte(i)
+
+		// multiply by generator 3
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+}
+
+func gf256Mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return g_gf256Exp[int(g_gf256Log[a])+int(g_gf256Log[b])]
+}
+
+func gf256Div(a, b byte) byte {
+	if b == 0 {
+		panic("division by zero")
+	}
+
+	if a == 0 {
+		return 0
+	}
+
+	return g_gf256Exp[int(g_gf256Log[a])+255-int(g_gf256Log[b])]
+}
+
+// evaluates the polynomial through the points (xs[i], ys[i]) at x by Lagrange interpolation
+func shamirInterpolate(xs []byte, ys [][]byte, x byte) []byte {
+	res := make([]byte, len(ys[0]))
+
+	for i := range xs {
+		// Lagrange basis polynomial i at x
+		l := byte(1)
+
+		for j := range xs {
+			if i != j {
+				l = gf256Mul(l, gf256Div(xs[j]^x, xs[j]^xs[i]))
+			}
+		}
+
+		for k := range res {
+			res[k] ^= gf256Mul(l, ys[i][k])
+		}
+	}
+
+	return res
+}
+
+func shamirDigest(r, secret []byte) []byte {
+	mac := hmac.New(sha256.New, r)
+	mac.Write(secret)
+
+	return mac.Sum(nil)[:shamirDigestLength]
+}
+
+// splits secret into n shares, any m of them reconstruct the secret
+// share i belongs to x coordinate i+1
+func shamirSplit(secret []byte, m, n int) ([][]byte, error) {
+	if m < 2 || m > n || n > shamirMaxShares || len(secret) <= shamirDigestLength {
+		return nil, errors.New("invalid share parameters")
+	}
+
+	digest := make([]byte, len(secret))
+	defer stellarwallet.EraseByteBuffer(digest)
+
+	if _, err := rand.Read(digest[shamirDigestLength:]); err != nil {
+		return nil, err
+	}
+
+	copy(digest, shamirDigest(digest[shamirDigestLength:], secret))
+
+	xs := []byte{shamirSecretIndex, shamirDigestIndex}
+	ys := [][]byte{secret, digest}
+
+	shares := make([][]byte, n)
+
+	for i := 0; i < m-2; i++ {
+		shares[i] = make([]byte, len(secret))
+
+		if _, err := rand.Read(shares[i]); err != nil {
+			return nil, err
+		}
+
+		xs = append(xs, byte(i+1))
+		ys = append(ys, shares[i])
+	}
+
+	for i := m - 2; i < n; i++ {
+		shares[i] = shamirInterpolate(xs, ys, byte(i+1))
+	}
+
+	return shares, nil
+}
+
+// reconstructs the secret from shares at distinct x coordinates and verifies it against the digest share
+func shamirCombine(xs []byte, shares [][]byte) ([]byte, error) {
+	if len(xs) < 2 || len(xs) != len(shares) {
+		return nil, errors.New("not enough shares")
+	}
+
+	digest := shamirInterpolate(xs, shares, shamirDigestIndex)
+	defer stellarwallet.EraseByteBuffer(digest)
+
+	secret := shamirInterpolate(xs, shares, shamirSecretIndex)
+
+	if !hmac.Equal(digest[:shamirDigestLength], shamirDigest(digest[shamirDigestLength:], secret)) {
+		stellarwallet.EraseByteBuffer(secret)
+		return nil, errors.New("digest mismatch, a share is wrong or belongs to another split")
+	}
+
+	return secret, nil
+}
+
+func (s *MnemonicShare) header() string {
+	return fmt.Sprintf("%s-%d-%d", s.set, s.threshold, s.index)
+}
+
+func parseShareHeader(h string) (*MnemonicShare, error) {
+	p := strings.Split(strings.TrimSpace(h), "-")
+
+	if len(p) != 3 || len(p[0]) != 8 {
+		return nil, errors.New("expected <set id>-<threshold>-<share number>")
+	}
+
+	if _, err := hex.DecodeString(p[0]); err != nil {
+		return nil, errors.New("invalid set id")
+	}
+
+	m, err := strconv.Atoi(p[1])
+	if err != nil || m < 2 || m > shamirMaxShares {
+		return nil, errors.New("invalid threshold")
+	}
+
+	x, err := strconv.Atoi(p[2])
+	if err != nil || x < 1 || x > shamirMaxShares {
+		return nil, errors.New("invalid share number")
+	}
+
+	return &MnemonicShare{set: strings.ToLower(p[0]), threshold: m, index: x}, nil
+}
+
+func printMnemonicShare(s *MnemonicShare, n int) {
+	fmt.Printf("\nShare %d of %d, %d shares required for recovery.\n", s.index, n, s.threshold)
+	fmt.Printf("Share ID: %s\n", s.header())
+	printMnemonicWords(s.words)
+	fmt.Println()
+}
+
+// wallet menu command: splits the wallet mnemonic into shares, each share is displayed separately
+func splitWalletMnemonic() {
+	unlockWallet(false)
+	defer unlockWalletPassword()
+
+	words := g_wallet.Bip39Mnemonic(&g_walletPassword)
+
+	defer func() {
+		for i := range words {
+			stellarwallet.EraseString(&words[i])
+		}
+	}()
+
+	entropy, err := bip39.EntropyFromMnemonic(strings.Join(words, " "))
+	if err != nil {
+		fmt.Printf("Invalid wallet mnemonic: %s\n", err.Error())
+		return
+	}
+
+	defer stellarwallet.EraseByteBuffer(entropy)
+
+	n := getInteger(fmt.Sprintf("Number of shares (2-%d)", shamirMaxShares))
+	if n < 2 || n > shamirMaxShares {
+		fmt.Println("Invalid number of shares.")
+		return
+	}
+
+	m := getInteger(fmt.Sprintf("Number of shares required for recovery (2-%d)", n))
+	if m < 2 || m > n {
+		fmt.Println("Invalid number of required shares.")
+		return
+	}
+
+	data, err := shamirSplit(entropy, m, n)
+	if err != nil {
+		fmt.Printf("Failed to split mnemonic: %s\n", err.Error())
+		return
+	}
+
+	// self check: first and last m shares must reconstruct the entropy
+	for _, first := range []int{0, n - m} {
+		xs := make([]byte, m)
+		for i := range xs {
+			xs[i] = byte(first + i + 1)
+		}
+
+		res, err := shamirCombine(xs, data[first:first+m])
+		if err != nil || !bytes.Equal(res, entropy) {
+			panic("Shamir secret sharing self check failed")
+		}
+		stellarwallet.EraseByteBuffer(res)
+	}
+
+	id := make([]byte, 4)
+	if _, err = rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("The mnemonic is split into %d shares, any %d of them recover the wallet.\n", n, m)
+	fmt.Println("The mnemonic password, if used, is not part of the shares.")
+	fmt.Println("Hand out each share to a different person. Make sure nobody else is watching the screen.")
+
+	for i := range data {
+		w, err := bip39.NewMnemonic(data[i])
+		if err != nil {
+			panic(err)
+		}
+
+		s := &MnemonicShare{set: hex.EncodeToString(id), threshold: m, index: i + 1, words: strings.Fields(w)}
+
+		readLine(fmt.Sprintf("Hit enter to display share %d of %d", i+1, n))
+
+		printMnemonicShare(s, n)
+
+		readLine("Hit enter to hide the share")
+
+		fmt.Print(strings.Repeat("\n", 60))
+	}
+
+	for i := range data {
+		stellarwallet.EraseByteBuffer(data[i])
+	}
+}
+
+func readMnemonicShare(shares []*MnemonicShare) *MnemonicShare {
+	for {
+		h := readLine(fmt.Sprintf("Share ID of share %d (hit enter to cancel)", len(shares)+1))
+
+		if h == "" {
+			return nil
+		}
+
+		s, err := parseShareHeader(h)
+		if err != nil {
+			fmt.Printf("Invalid share ID: %s\n", err.Error())
+			continue
+		}
+
+		if len(shares) > 0 && (s.set != shares[0].set || s.threshold != shares[0].threshold) {
+			fmt.Printf("Share does not belong to share set %s-%d.\n", shares[0].set, shares[0].threshold)
+			continue
+		}
+
+		dup := false
+		for _, o := range shares {
+			if o.index == s.index {
+				dup = true
+			}
+		}
+
+		if dup {
+			fmt.Printf("Share %d was already entered.\n", s.index)
+			continue
+		}
+
+		for {
+			fmt.Printf("Enter the 24 words of share %d:\n", s.index)
+			s.words = getWordList()
+
+			if _, err = bip39.EntropyFromMnemonic(strings.Join(s.words, " ")); err == nil {
+				return s
+			}
+
+			fmt.Println("Invalid share words (checksum mismatch), please re-enter.")
+		}
+	}
+}
+
+// recovers the wallet from mnemonic shares
+func recoverWalletFromShares() {
+	var pw, wpw string
+
+	defer stellarwallet.EraseString(&pw)
+	defer stellarwallet.EraseString(&wpw)
+
+	fmt.Println("Recovering wallet from mnemonic shares...")
+
+	var shares []*MnemonicShare
+
+	for len(shares) == 0 || len(shares) < shares[0].threshold {
+		s := readMnemonicShare(shares)
+		if s == nil {
+			return
+		}
+
+		shares = append(shares, s)
+
+		fmt.Printf("Share %d accepted, %d of %d required shares entered.\n", s.index, len(shares), shares[0].threshold)
+	}
+
+	xs := make([]byte, len(shares))
+	data := make([][]byte, len(shares))
+
+	for i, s := range shares {
+		xs[i] = byte(s.index)
+		data[i], _ = bip39.EntropyFromMnemonic(strings.Join(s.words, " "))
+	}
+
+	entropy, err := shamirCombine(xs, data)
+	if err != nil {
+		fmt.Printf("Failed to recover mnemonic: %s\n", err.Error())
+		return
+	}
+
+	defer stellarwallet.EraseByteBuffer(entropy)
+
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		fmt.Printf("Failed to reconstruct mnemonic: %s\n", err.Error())
+		return
+	}
+
+	fmt.Println()
+	fmt.Println("Define new  password for the wallet - this is not the mnemonic password.")
+
+	getPasswordWithConfirmation("Wallet Password", true, &pw)
+	getPasswordWithConfirmation("Mnemonic Password", false, &wpw)
+
+	g_wallet = stellarwallet.NewWalletFromMnemonic(stellarwallet.WalletFlagSignAll, &pw, strings.Fields(mnemonic), &wpw)
+
+	stellarwallet.EraseString(&mnemonic)
+
+	if g_wallet == nil {
+		fmt.Println("Invalid mnemonic words.")
+		return
+	}
+
+	finishWalletRecovery(&pw)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+// reference multiplication in GF(256): carry-less multiplication reduced by x^8+x^4+x^3+x+1
+func gf256MulSlow(a, b byte) byte {
+	var p byte
+
+	for b != 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+
+		hi := a & 0x80
+		a <<= 1
+		if hi != 0 {
+			a ^= 0x1b
+		}
+
+		b >>= 1
+	}
+
+	return p
+}
+
+func TestGf256MulDiv(t *testing.T) {
+	for a := 0; a < 256; a++ {
+		if gf256Mul(byte(a), 1) != byte(a) || gf256Mul(byte(a), 0) != 0 {
+			t.Fatalf("multiplicative identity or zero failed for %d", a)
+		}
+
+		for b := 0; b < 256; b++ {
+			p := gf256Mul(byte(a), byte(b))
+
+			if p != gf256MulSlow(byte(a), byte(b)) {
+				t.Fatalf("%d * %d = %d, expected %d", a, b, p, gf256MulSlow(byte(a), byte(b)))
+			}
+
+			if p != gf256Mul(byte(b), byte(a)) {
+				t.Fatalf("multiplication of %d and %d not commutative", a, b)
+			}
+
+			if b != 0 && gf256Div(p, byte(b)) != byte(a) {
+				t.Fatalf("(%d * %d) / %d != %d", a, b, b, a)
+			}
+		}
+
+		if a != 0 && gf256Mul(byte(a), gf256Div(1, byte(a))) != 1 {
+			t.Fatalf("inverse of %d failed", a)
+		}
+	}
+}
+
+func TestGf256DivByZero(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("division by zero did not panic")
+		}
+	}()
+
+	gf256Div(1, 0)
+}
+
+func randomSecret(t *testing.T) []byte {
+	secret := make([]byte, 32)
+
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+
+	return secret
+}
+
+// combines the shares selected by the bits of mask
+func combineSubset(shares [][]byte, mask int) ([]byte, int, error) {
+	var xs []byte
+	var data [][]byte
+
+	for i := range shares {
+		if mask&(1<<uint(i)) != 0 {
+			xs = append(xs, byte(i+1))
+			data = append(data, shares[i])
+		}
+	}
+
+	res, err := shamirCombine(xs, data)
+
+	return res, len(xs), err
+}
+
+func TestShamirInterpolate(t *testing.T) {
+	xs := []byte{1, 2, 3}
+	ys := [][]byte{{5}, {7}, {11}}
+
+	for i, x := range xs {
+		if y := shamirInterpolate(xs, ys, x); y[0] != ys[i][0] {
+			t.Errorf("interpolation at %d: %d, expected %d", x, y[0], ys[i][0])
+		}
+	}
+}
+
+func TestShamirSplitCombine(t *testing.T) {
+	for n := 2; n <= 6; n++ {
+		for m := 2; m <= n; m++ {
+			secret := randomSecret(t)
+
+			shares, err := shamirSplit(secret, m, n)
+			if err != nil {
+				t.Fatalf("%d of %d: split failed: %s", m, n, err.Error())
+			}
+
+			if len(shares) != n {
+				t.Fatalf("%d of %d: got %d shares", m, n, len(shares))
+			}
+
+			for mask := 1; mask < 1<<uint(n); mask++ {
+				res, cnt, err := combineSubset(shares, mask)
+
+				if cnt >= m && (err != nil || !bytes.Equal(res, secret)) {
+					t.Errorf("%d of %d: subset %b does not reconstruct the secret: %v", m, n, mask, err)
+				}
+
+				if cnt < m && err == nil {
+					t.Errorf("%d of %d: subset %b of %d shares passes the digest check", m, n, mask, cnt)
+				}
+			}
+		}
+	}
+}
+
+func TestShamirMaxShares(t *testing.T) {
+	secret := randomSecret(t)
+
+	shares, err := shamirSplit(secret, 3, shamirMaxShares)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, _, err := combineSubset(shares, 1<<0|1<<7|1<<(shamirMaxShares-1))
+
+	if err != nil || !bytes.Equal(res, secret) {
+		t.Error("shares 1, 8 and 16 do not reconstruct the secret")
+	}
+}
+
+func TestShamirInvalidParameters(t *testing.T) {
+	secret := randomSecret(t)
+
+	for _, p := range [][2]int{{1, 3}, {4, 3}, {2, shamirMaxShares + 1}} {
+		if _, err := shamirSplit(secret, p[0], p[1]); err == nil {
+			t.Errorf("split %d of %d accepted", p[0], p[1])
+		}
+	}
+}
+
+func TestShamirDigest(t *testing.T) {
+	secret := randomSecret(t)
+
+	shares, err := shamirSplit(secret, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a share at the wrong x coordinate
+	if _, err = shamirCombine([]byte{1, 3}, shares[:2]); err == nil {
+		t.Error("digest does not detect a wrong share number")
+	}
+
+	// a corrupted share
+	bad := append([]byte{}, shares[1]...)
+	bad[0] ^= 1
+
+	if _, err = shamirCombine([]byte{1, 2}, [][]byte{shares[0], bad}); err == nil {
+		t.Error("digest does not detect a corrupted share")
+	}
+
+	// shares of two splits of the same secret must not be mixed
+	other, err := shamirSplit(secret, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = shamirCombine([]byte{1, 2}, [][]byte{shares[0], other[1]}); err == nil {
+		t.Error("digest does not detect shares of different splits")
+	}
+}
+
+func TestParseShareHeader(t *testing.T) {
+	s, err := parseShareHeader(" 0A1B2C3D-3-2 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.set != "0a1b2c3d" || s.threshold != 3 || s.index != 2 || s.header() != "0a1b2c3d-3-2" {
+		t.Errorf("unexpected share %+v", s)
+	}
+
+	for _, h := range []string{"", "0a1b2c3d-3", "0a1b2c-3-2", "0a1b2c3x-3-2", "0a1b2c3d-1-2", "0a1b2c3d-3-0",
+		"0a1b2c3d-3-17"} {
+		if _, err := parseShareHeader(h); err == nil {
+			t.Errorf("invalid share header \"%s\" accepted", h)
+		}
+	}
+}
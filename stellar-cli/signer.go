@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/xdr"
+)
+
+// Signers produce transaction signatures for a public key. Private keys held in memory (g_signers)
+// are wrapped by SeedSigner, keys of external signers (HSM, hardware device, remote signing service)
+// by ExternalKeySigner.
+//
+// External signer protocol: newline delimited JSON messages, each request is answered by exactly one
+// response carrying the same id. Transport is either stdin/stdout of a child process
+// (--external-signer exec:<command> [args]) or a Unix domain socket (--external-signer unix:<path>).
+//
+//   {"id":1,"method":"public_keys"}
+//   {"id":1,"public_keys":["G..."]}
+//
+//   {"id":2,"method":"sign","public_key":"G...","network_passphrase":"...","hash":"<hex>","transaction":"<base64 envelope>"}
+//   {"id":2,"signature":"<base64 ed25519 signature of hash>"}
+//
+// Failures are reported as {"id":n,"error":"<message>"}. The transaction is passed so that the signer
+// can display or check it, the signature must be created over the hash. Returned signatures are verified
+// before being added to the transaction.
+// A signer not responding within --external-signer-timeout seconds is disconnected.
+// A reference implementation is provided in the mocksigner directory, the key agent (agent.go) serves
+// the keys of a wallet.
+
+const (
+	SignerMethodPublicKeys = "public_keys"
+	SignerMethodSign       = "sign"
+)
+
+type Signer interface {
+	PublicKey() string
+	Sign(txe *xdr.TransactionEnvelope, hash [32]byte) (xdr.DecoratedSignature, error)
+}
+
+type SignerRequest struct {
+	Id                uint64 `json:"id"`
+	Method            string `json:"method"`
+	PublicKey         string `json:"public_key,omitempty"`
+	NetworkPassphrase string `json:"network_passphrase,omitempty"`
+	Hash              string `json:"hash,omitempty"`
+	Transaction       string `json:"transaction,omitempty"`
+}
+
+type SignerResponse struct {
+	Id         uint64   `json:"id"`
+	Error      string   `json:"error,omitempty"`
+	PublicKeys []string `json:"public_keys,omitempty"`
+	Signature  string   `json:"signature,omitempty"`
+}
+
+// private key held in memory
+type SeedSigner struct {
+	seed string
+}
+
+func (s *SeedSigner) PublicKey() string {
+	return keypair.MustParse(s.seed).Address()
+}
+
+func (s *SeedSigner) Sign(txe *xdr.TransactionEnvelope, hash [32]byte) (xdr.DecoratedSignature, error) {
+	return keypair.MustParse(s.seed).SignDecorated(hash[:])
+}
+
+// connection to an external signer process or socket
+type ExternalSigner struct {
+	name    string
+	r       *bufio.Reader
+	w       io.Writer
+	closer  func()
+	abort   func() // terminates the connection, unblocks a pending read
+	timeout time.Duration
+	failed  error // set if the connection was aborted, no further requests are sent
+	nextId  uint64
+	keys    []string
+	mutex   sync.Mutex
+}
+
+// single key of an external signer
+type ExternalKeySigner struct {
+	signer *ExternalSigner
+	pubkey string
+}
+
+type stringListFlag []string
+
+func (l *stringListFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringListFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+var (
+	g_externalSignerSpecs stringListFlag
+	g_externalSigners []*ExternalSigner
+	g_externalSignerTimeout int // seconds
+
+	// keys of external signers selected for signing, counterpart of g_signers
+	g_externalKeys []*ExternalKeySigner
+)
+
+// connects to an external signer given as exec:<command> [args] or unix:<socket path>
+func connectExternalSigner(spec string) (*ExternalSigner, error) {
+	es := &ExternalSigner{name: spec, timeout: time.Duration(g_externalSignerTimeout) * time.Second}
+
+	switch {
+	case strings.HasPrefix(spec, "unix:"):
+		conn, err := net.Dial("unix", spec[len("unix:"):])
+		if err != nil {
+			return nil, err
+		}
+		es.r = bufio.NewReader(conn)
+		es.w = conn
+		es.closer = func() { conn.Close() }
+		es.abort = es.closer
+
+	default:
+		args := strings.Fields(strings.TrimPrefix(spec, "exec:"))
+		if len(args) == 0 {
+			return nil, errors.New("missing command")
+		}
+
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+
+		if err = cmd.Start(); err != nil {
+			return nil, err
+		}
+
+		es.r = bufio.NewReader(stdout)
+		es.w = stdin
+		es.closer = func() {
+			stdin.Close()
+			cmd.Wait()
+		}
+		es.abort = func() { cmd.Process.Kill() }
+	}
+
+	var resp SignerResponse
+
+	err := es.call(&SignerRequest{Method: SignerMethodPublicKeys}, &resp)
+	if err != nil {
+		es.close()
+		return nil, err
+	}
+
+	for _, k := range resp.PublicKeys {
+		if !isValidPublicKey(k) {
+			es.close()
+			return nil, fmt.Errorf("invalid public key: %s", k)
+		}
+	}
+
+	es.keys = resp.PublicKeys
+
+	return es, nil
+}
+
+func (es *ExternalSigner) call(req *SignerRequest, resp *SignerResponse) error {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	if es.failed != nil {
+		return es.failed
+	}
+
+	es.nextId++
+	req.Id = es.nextId
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if _, err = es.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	line, err := es.readLine()
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(line, resp); err != nil {
+		return fmt.Errorf("invalid response: %s", err.Error())
+	}
+
+	if resp.Id != req.Id {
+		return fmt.Errorf("response id %d does not match request id %d", resp.Id, req.Id)
+	}
+
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+
+	return nil
+}
+
+// reads a response line, aborts the connection if no response arrives within es.timeout
+// must be called with es.mutex held
+func (es *ExternalSigner) readLine() ([]byte, error) {
+	if es.timeout <= 0 {
+		return es.r.ReadBytes('\n')
+	}
+
+	type result struct {
+		line []byte
+		err  error
+	}
+
+	ch := make(chan result, 1)
+
+	go func() {
+		line, err := es.r.ReadBytes('\n')
+		ch <- result{line, err}
+	}()
+
+	select {
+	case res := <-ch:
+		return res.line, res.err
+
+	case <-time.After(es.timeout):
+		if es.abort != nil {
+			es.abort()
+		}
+		es.failed = fmt.Errorf("no response within %s, signer disconnected", es.timeout)
+		return nil, es.failed
+	}
+}
+
+func (es *ExternalSigner) close() {
+	if es.closer != nil {
+		es.closer()
+	}
+}
+
+func (es *ExternalKeySigner) PublicKey() string {
+	return es.pubkey
+}
+
+func (es *ExternalKeySigner) Sign(txe *xdr.TransactionEnvelope, hash [32]byte) (xdr.DecoratedSignature, error) {
+	var ds xdr.DecoratedSignature
+
+	blob, err := xdr.MarshalBase64(txe)
+	if err != nil {
+		return ds, err
+	}
+
+	req := &SignerRequest{Method: SignerMethodSign, PublicKey: es.pubkey, NetworkPassphrase: g_network.Passphrase,
+		Hash: hex.EncodeToString(hash[:]), Transaction: blob}
+
+	var resp SignerResponse
+
+	if err = es.signer.call(req, &resp); err != nil {
+		return ds, err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(resp.Signature)
+	if err != nil {
+		return ds, errors.New("invalid signature encoding")
+	}
+
+	kp := keypair.MustParse(es.pubkey)
+
+	if kp.Verify(hash[:], sig) != nil {
+		return ds, errors.New("invalid signature")
+	}
+
+	ds.Hint = xdr.SignatureHint(kp.Hint())
+	ds.Signature = xdr.Signature(sig)
+
+	return ds, nil
+}
+
+func setupExternalSigners() {
+	if s := os.Getenv(agentSocketEnv); s != "" && g_agentSocket == "" {
+		g_externalSignerSpecs = append(g_externalSignerSpecs, "unix:" + s)
+	}
+
+	for _, spec := range g_externalSignerSpecs {
+		es, err := connectExternalSigner(spec)
+
+		if err != nil {
+			fmt.Printf("Failed to connect to external signer \"%s\": %s\n", spec, err.Error())
+			continue
+		}
+
+		fmt.Printf("External signer \"%s\": %d key(s)\n", spec, len(es.keys))
+
+		g_externalSigners = append(g_externalSigners, es)
+	}
+}
+
+func closeExternalSigners() {
+	for _, es := range g_externalSigners {
+		es.close()
+	}
+
+	g_externalSigners = nil
+}
+
+// returns the external signer providing given public key or nil
+func findExternalSigner(pubkey string) *ExternalKeySigner {
+	for _, es := range g_externalSigners {
+		for _, k := range es.keys {
+			if k == pubkey {
+				return &ExternalKeySigner{es, k}
+			}
+		}
+	}
+
+	return nil
+}
+
+func isExternalKeySelected(pubkey string) bool {
+	for _, es := range g_externalKeys {
+		if es.pubkey == pubkey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selects an external signer key for signing, returns false if no external signer provides the key
+func selectExternalKey(pubkey string) bool {
+	if isExternalKeySelected(pubkey) {
+		return true
+	}
+
+	es := findExternalSigner(pubkey)
+
+	if es == nil {
+		return false
+	}
+
+	g_externalKeys = append(g_externalKeys, es)
+
+	return true
+}
+
+// number of selected signing keys, private keys and external signer keys
+func signerCount() int {
+	return len(g_signers) + len(g_externalKeys)
+}
+
+// returns all selected signers
+func activeSigners() []Signer {
+	res := make([]Signer, 0, signerCount())
+
+	for _, s := range g_signers {
+		res = append(res, &SeedSigner{s})
+	}
+
+	for _, es := range g_externalKeys {
+		res = append(res, es)
+	}
+
+	return res
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stellar/go/build"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/xdr"
+)
+
+// builds the mock signer into a temporary directory, returns the binary path and a cleanup function
+func buildMockSigner(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "mocksigner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bin := filepath.Join(dir, "mocksigner")
+
+	out, err := exec.Command("go", "build", "-o", bin, "./mocksigner").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("building mocksigner failed: %s\n%s", err.Error(), out)
+	}
+
+	return bin, func() { os.RemoveAll(dir) }
+}
+
+func connectMockSigner(t *testing.T, bin, mode string, kp *keypair.Full) *ExternalSigner {
+	es, err := connectExternalSigner(fmt.Sprintf("exec:%s -seeds %s -mode %s", bin, kp.Seed(), mode))
+	if err != nil {
+		t.Fatalf("connecting mocksigner failed: %s", err.Error())
+	}
+
+	return es
+}
+
+func testEnvelope(t *testing.T, kp *keypair.Full) (*xdr.TransactionEnvelope, [32]byte) {
+	txe := &xdr.TransactionEnvelope{}
+
+	if err := txe.Tx.SourceAccount.SetAddress(kp.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	txe.Tx.Fee = 100
+	txe.Tx.SeqNum = 1
+
+	hash, err := network.HashTransaction(&txe.Tx, g_network.Passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return txe, hash
+}
+
+func expectSignError(t *testing.T, es *ExternalSigner, kp *keypair.Full, pubkey, msg string) {
+	txe, hash := testEnvelope(t, kp)
+
+	_, err := (&ExternalKeySigner{es, pubkey}).Sign(txe, hash)
+
+	if err == nil || !strings.Contains(err.Error(), msg) {
+		t.Errorf("expected error \"%s\", got %v", msg, err)
+	}
+}
+
+func TestExternalSigner(t *testing.T) {
+	g_network = build.TestNetwork
+	g_externalSignerTimeout = 5
+
+	bin, cleanup := buildMockSigner(t)
+	defer cleanup()
+
+	kp, err := keypair.Random()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := keypair.Random()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("public_keys", func(t *testing.T) {
+		es := connectMockSigner(t, bin, "ok", kp)
+		defer es.close()
+
+		if len(es.keys) != 1 || es.keys[0] != kp.Address() {
+			t.Errorf("unexpected public keys %v", es.keys)
+		}
+	})
+
+	t.Run("sign", func(t *testing.T) {
+		es := connectMockSigner(t, bin, "ok", kp)
+		defer es.close()
+
+		txe, hash := testEnvelope(t, kp)
+
+		ds, err := (&ExternalKeySigner{es, kp.Address()}).Sign(txe, hash)
+		if err != nil {
+			t.Fatalf("signing failed: %s", err.Error())
+		}
+
+		if ds.Hint != xdr.SignatureHint(kp.Hint()) {
+			t.Error("signature hint does not match key")
+		}
+
+		if err = kp.Verify(hash[:], ds.Signature); err != nil {
+			t.Errorf("signature does not verify: %s", err.Error())
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		es := connectMockSigner(t, bin, "ok", kp)
+		defer es.close()
+
+		expectSignError(t, es, kp, other.Address(), "unknown public key")
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		es := connectMockSigner(t, bin, "reject", kp)
+		defer es.close()
+
+		expectSignError(t, es, kp, kp.Address(), "signing rejected")
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		es := connectMockSigner(t, bin, "corrupt", kp)
+		defer es.close()
+
+		expectSignError(t, es, kp, kp.Address(), "invalid signature")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		g_externalSignerTimeout = 1
+		defer func() { g_externalSignerTimeout = 5 }()
+
+		es := connectMockSigner(t, bin, "hang", kp)
+		defer es.close()
+
+		expectSignError(t, es, kp, kp.Address(), "no response")
+
+		// the aborted connection must not be used again
+		expectSignError(t, es, kp, kp.Address(), "no response")
+	})
+}
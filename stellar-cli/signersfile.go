@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/mua69/stellarwallet"
+	"github.com/stellar/go/keypair"
+)
+
+// Encrypted signers file format:
+// The first line holds a format tag, the base64 encoded scrypt salt and a base64 encoded check token
+// (a known text encrypted with the file key) used to verify the password before keys are added.
+// Each following line holds the public key and the base64 encoded secretbox encrypted seed:
+//
+//   STELLAR-CLI-SIGNERS-1 <salt> <check>
+//   G... <encrypted seed>
+//
+// Public keys are stored in plain text, so the file can be listed without the password.
+// The key is derived with scrypt from a password or from the content of a key file (--signers-key-file).
+
+const (
+	signersFileFormatTag = "STELLAR-CLI-SIGNERS-1"
+	signersFileCheckText = "stellar-cli signers file"
+)
+
+type EncryptedSigner struct {
+	pubkey string
+	enc []byte
+}
+
+type EncryptedSignersFile struct {
+	salt []byte
+	check []byte
+	signers []*EncryptedSigner
+}
+
+// returns true if the file starts with the encrypted signers file format tag
+func isEncryptedSignersFile(fileName string) bool {
+	fp, err := os.Open(fileName)
+
+	if err != nil {
+		return false
+	}
+
+	defer fp.Close()
+
+	tag := make([]byte, len(signersFileFormatTag))
+
+	n, _ := fp.Read(tag)
+
+	return n == len(tag) && string(tag) == signersFileFormatTag
+}
+
+func loadEncryptedSignersFile(fileName string) (*EncryptedSignersFile, error) {
+	data, err := ioutil.ReadFile(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	scan := bufio.NewScanner(bytes.NewReader(data))
+
+	if !scan.Scan() {
+		return nil, errors.New("empty signers file")
+	}
+
+	f := strings.Fields(scan.Text())
+
+	if len(f) != 3 || f[0] != signersFileFormatTag {
+		return nil, errors.New("invalid signers file header")
+	}
+
+	sf := new(EncryptedSignersFile)
+
+	if sf.salt, err = base64.StdEncoding.DecodeString(f[1]); err != nil {
+		return nil, errors.New("invalid signers file header")
+	}
+
+	if sf.check, err = base64.StdEncoding.DecodeString(f[2]); err != nil {
+		return nil, errors.New("invalid signers file header")
+	}
+
+	for line := 2; scan.Scan(); line++ {
+		s := strings.TrimSpace(scan.Text())
+
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+
+		f := strings.Fields(s)
+
+		if len(f) != 2 || !isValidPublicKey(f[0]) {
+			return nil, fmt.Errorf("line %d: invalid entry", line)
+		}
+
+		enc, err := base64.StdEncoding.DecodeString(f[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+
+		sf.signers = append(sf.signers, &EncryptedSigner{f[0], enc})
+	}
+
+	return sf, scan.Err()
+}
+
+// writes the signers file: temporary file, sync and rename
+func (sf *EncryptedSignersFile) save(fileName string) error {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "%s %s %s\n", signersFileFormatTag, base64.StdEncoding.EncodeToString(sf.salt),
+		base64.StdEncoding.EncodeToString(sf.check))
+
+	for _, s := range sf.signers {
+		fmt.Fprintf(&b, "%s %s\n", s.pubkey, base64.StdEncoding.EncodeToString(s.enc))
+	}
+
+	tmpName := fileName + ".tmp"
+
+	fp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = fp.Write(b.Bytes())
+	if err == nil {
+		err = fp.Sync()
+	}
+	if err != nil {
+		fp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err = fp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, fileName)
+}
+
+// derives the file key and verifies it with the check token
+func (sf *EncryptedSignersFile) unlock(pw *string) (*[32]byte, error) {
+	key := deriveKey(pw, sf.salt)
+
+	check, err := decryptBytes(key, sf.check)
+
+	if err != nil || string(check) != signersFileCheckText {
+		eraseKey(key)
+		return nil, errors.New("invalid signers file password")
+	}
+
+	return key, nil
+}
+
+func (sf *EncryptedSignersFile) findSigner(pubkey string) *EncryptedSigner {
+	for _, s := range sf.signers {
+		if s.pubkey == pubkey {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func (sf *EncryptedSignersFile) addSigner(key *[32]byte, seed string) bool {
+	pubkey := keypair.MustParse(seed).Address()
+
+	if sf.findSigner(pubkey) != nil {
+		return false
+	}
+
+	sf.signers = append(sf.signers, &EncryptedSigner{pubkey, encryptBytes(key, []byte(seed))})
+
+	return true
+}
+
+// decrypts all seeds, each seed is checked against the stored public key
+func (sf *EncryptedSignersFile) decryptSeeds(key *[32]byte) ([]string, error) {
+	var seeds []string
+
+	for _, s := range sf.signers {
+		data, err := decryptBytes(key, s.enc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", s.pubkey, err.Error())
+		}
+
+		seed := string(data)
+		eraseBytes(data)
+
+		kp, err := keypair.Parse(seed)
+		if err != nil || kp.Address() != s.pubkey {
+			return nil, fmt.Errorf("%s: decrypted key does not match public key", s.pubkey)
+		}
+
+		seeds = append(seeds, seed)
+	}
+
+	return seeds, nil
+}
+
+func newEncryptedSignersFile(pw *string) (*EncryptedSignersFile, *[32]byte) {
+	sf := &EncryptedSignersFile{salt: newSalt()}
+
+	key := deriveKey(pw, sf.salt)
+	sf.check = encryptBytes(key, []byte(signersFileCheckText))
+
+	return sf, key
+}
+
+// returns the signers file password, read from the key file (--signers-key-file) or the terminal
+func getSignersFilePassword(confirm bool, pw *string) error {
+	if g_signersKeyFile != "" {
+		data, err := ioutil.ReadFile(g_signersKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read key file: %s", err.Error())
+		}
+
+		*pw = strings.TrimRight(string(data), "\r\n")
+		eraseBytes(data)
+
+		if *pw == "" {
+			return errors.New("key file is empty")
+		}
+
+		return nil
+	}
+
+	if confirm {
+		getPasswordWithConfirmation("Signers File Password", true, pw)
+	} else {
+		getPassword("Signers File Password", true, pw)
+	}
+
+	return nil
+}
+
+// reads and decrypts an encrypted signers file and adds the keys to g_signers
+func readEncryptedSignersFile(fileName string) (int, error) {
+	sf, err := loadEncryptedSignersFile(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	var pw string
+	defer stellarwallet.EraseString(&pw)
+
+	if err = getSignersFilePassword(false, &pw); err != nil {
+		return 0, err
+	}
+
+	key, err := sf.unlock(&pw)
+	if err != nil {
+		return 0, err
+	}
+
+	defer eraseKey(key)
+
+	seeds, err := sf.decryptSeeds(key)
+	if err != nil {
+		return 0, err
+	}
+
+	cnt := 0
+
+	for i := range seeds {
+		if len(g_signers) < MaxTransactionSignatures {
+			g_signers = append(g_signers, seeds[i])
+			cnt++
+		} else {
+			stellarwallet.EraseString(&seeds[i])
+		}
+	}
+
+	return cnt, nil
+}
+
+func enterSignersFileName() string {
+	return readLine(fmt.Sprintf("Signers file name (hit enter for \"%s\")", g_signersFile))
+}
+
+func signersFileName(name string) string {
+	if name == "" {
+		return g_signersFile
+	}
+
+	return name
+}
+
+func createSignersFile() {
+	fileName := readLine("New signers file name")
+
+	if fileName == "" {
+		return
+	}
+
+	if _, err := os.Stat(fileName); err == nil {
+		fmt.Printf("File \"%s\" already exists.\n", fileName)
+		return
+	}
+
+	var pw string
+	defer stellarwallet.EraseString(&pw)
+
+	if err := getSignersFilePassword(true, &pw); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	sf, key := newEncryptedSignersFile(&pw)
+	defer eraseKey(key)
+
+	if err := sf.save(fileName); err != nil {
+		fmt.Printf("Failed to write signers file \"%s\": %s\n", fileName, err.Error())
+		return
+	}
+
+	fmt.Printf("Encrypted signers file created: %s\n", fileName)
+
+	addKeysToSignersFile(fileName, sf, key)
+}
+
+// reads private keys from the terminal and adds them to the signers file
+func addKeysToSignersFile(fileName string, sf *EncryptedSignersFile, key *[32]byte) {
+	for {
+		seed := getSeed("Private key to add (hit enter when done)", true)
+
+		if seed == "" {
+			return
+		}
+
+		added := sf.addSigner(key, seed)
+		pubkey := keypair.MustParse(seed).Address()
+		stellarwallet.EraseString(&seed)
+
+		if !added {
+			fmt.Printf("Key %s already present.\n", pubkey)
+			continue
+		}
+
+		if err := sf.save(fileName); err != nil {
+			fmt.Printf("Failed to write signers file \"%s\": %s\n", fileName, err.Error())
+			return
+		}
+
+		fmt.Printf("Added key %s.\n", pubkey)
+	}
+}
+
+func addSignersFileKeys() {
+	fileName := signersFileName(enterSignersFileName())
+
+	if fileName == "" {
+		return
+	}
+
+	sf, err := loadEncryptedSignersFile(fileName)
+	if err != nil {
+		fmt.Printf("Failed to read signers file \"%s\": %s\n", fileName, err.Error())
+		return
+	}
+
+	var pw string
+	defer stellarwallet.EraseString(&pw)
+
+	if err = getSignersFilePassword(false, &pw); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	key, err := sf.unlock(&pw)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	defer eraseKey(key)
+
+	addKeysToSignersFile(fileName, sf, key)
+}
+
+func listSignersFile() {
+	fileName := signersFileName(enterSignersFileName())
+
+	if fileName == "" {
+		return
+	}
+
+	sf, err := loadEncryptedSignersFile(fileName)
+	if err != nil {
+		fmt.Printf("Failed to read signers file \"%s\": %s\n", fileName, err.Error())
+		return
+	}
+
+	fmt.Printf("\nPublic keys in signers file %s:\n", fileName)
+
+	if len(sf.signers) == 0 {
+		fmt.Println("none")
+		return
+	}
+
+	var table [][]string
+
+	for i, s := range sf.signers {
+		desc := ""
+		if g_wallet != nil {
+			if a := g_wallet.FindAccountByPublicKey(s.pubkey); a != nil {
+				desc = a.Description()
+			}
+		}
+		table = appendTableLine(table, fmt.Sprintf("%d", i+1), s.pubkey, desc)
+	}
+
+	printTable(table, 3, " ")
+}
+
+// converts a plain text signers file to the encrypted format, the plain text file is left untouched
+func encryptSignersFile() {
+	srcName := readLine("Plain text signers file")
+
+	if srcName == "" {
+		return
+	}
+
+	if isEncryptedSignersFile(srcName) {
+		fmt.Println("File is already encrypted.")
+		return
+	}
+
+	dstName := readLine("Encrypted signers file name")
+
+	if dstName == "" {
+		return
+	}
+
+	if _, err := os.Stat(dstName); err == nil {
+		fmt.Printf("File \"%s\" already exists.\n", dstName)
+		return
+	}
+
+	fp, err := os.Open(srcName)
+	if err != nil {
+		fmt.Printf("Failed to read signers file \"%s\": %s\n", srcName, err.Error())
+		return
+	}
+
+	defer fp.Close()
+
+	var pw string
+	defer stellarwallet.EraseString(&pw)
+
+	if err = getSignersFilePassword(true, &pw); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	sf, key := newEncryptedSignersFile(&pw)
+	defer eraseKey(key)
+
+	scan := bufio.NewScanner(fp)
+
+	for scan.Scan() {
+		line := scan.Text()
+
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[0:i]
+		}
+
+		kp, err := keypair.Parse(strings.TrimSpace(line))
+		if err != nil {
+			continue
+		}
+
+		if kpf, ok := kp.(*keypair.Full); ok {
+			sf.addSigner(key, kpf.Seed())
+		}
+	}
+
+	if err = scan.Err(); err != nil {
+		fmt.Printf("Failed to read signers file \"%s\": %s\n", srcName, err.Error())
+		return
+	}
+
+	if err = sf.save(dstName); err != nil {
+		fmt.Printf("Failed to write signers file \"%s\": %s\n", dstName, err.Error())
+		return
+	}
+
+	fmt.Printf("Encrypted %d key(s) to %s. Remember to securely delete the plain text file %s.\n",
+		len(sf.signers), dstName, srcName)
+}
+
+func signersFileMenu() {
+	menu := []MenuEntryCB{
+		{ createSignersFile, "Create Encrypted Signers File", true},
+		{ addSignersFileKeys, "Add Keys to Encrypted Signers File", true},
+		{ listSignersFile, "List Public Keys of Encrypted Signers File", true},
+		{ encryptSignersFile, "Encrypt Plain Text Signers File", true}}
+
+	runCallbackMenu(menu, "SIGNERS FILE: Select Action", true)
+}
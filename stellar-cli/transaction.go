@@ -18,6 +18,8 @@ import (
 	"os"
 	"bufio"
 	"github.com/pkg/errors"
+	"github.com/stellar/go/network"
+	"time"
 )
 
 
@@ -25,6 +27,10 @@ func loadAccount(adr string) (*horizon.Account, error) {
 
 	kp := keypair.MustParse(adr)
 
+	if !g_online {
+		return nil, errors.New("offline mode: cannot load account " + kp.Address())
+	}
+
 	acc, err := g_horizon.LoadAccount(kp.Address())
 
 	if err != nil {
@@ -75,10 +81,34 @@ func getAccountTransactions(adr string, cnt int, pagingTokenIn string) (txs []ho
 
 	//fmt.Println(url)
 	
-	resp, err := g_horizon.HTTP.Get(url)
+	err = horizonGetJson(url, &obj)
 	if err != nil {
 		return
 	}
+
+	n := len(obj.Embedded.Records) 
+	
+	if n == 0 {
+		return
+	}
+	
+	if n >= cnt {
+		// there are probably more transaction records, return paging token of last transaction
+		pagingTokenOut = obj.Embedded.Records[n-1].PT
+	}
+
+	txs = obj.Embedded.Records
+
+	return
+}
+
+// performs a GET request on the horizon server and decodes the JSON response into obj
+// returns a *horizon.Error if the server reports a problem
+func horizonGetJson(url string, obj interface{}) error {
+	resp, err := g_horizon.HTTP.Get(url)
+	if err != nil {
+		return err
+	}
 		
 	defer resp.Body.Close()
 	decoder := json.NewDecoder(resp.Body)
@@ -89,37 +119,60 @@ func getAccountTransactions(adr string, cnt int, pagingTokenIn string) (txs []ho
 		}
 		decodeError := decoder.Decode(&horizonError.Problem)
 		if decodeError != nil {
-			err = errors.New("error decoding horizon.Problem")
-			return
+			return errors.New("error decoding horizon.Problem")
 		}
-		err = horizonError
-		return
+		return horizonError
 	}
 
-	err = decoder.Decode(&obj)
-	if err != nil {
-		return
-	}
+	return decoder.Decode(obj)
+}
 
-	n := len(obj.Embedded.Records) 
-	
-	if n == 0 {
-		return
-	}
-	
-	if n >= cnt {
-		// there are probably more transaction records, return paging token of last transaction
-		pagingTokenOut = obj.Embedded.Records[n-1].PT
-	}
+// returns all accounts having the given public key as signer
+func getAccountsForSigner(signer string) ([]horizon.Account, error) {
+	var res []horizon.Account
+	var cursor string
+
+	baseUrl := strings.TrimRight(g_horizon.URL, "/") + "/accounts?signer=" + signer + "&limit=200"
+
+	for {
+		var obj struct {
+			Embedded struct {
+				Records []struct {
+					horizon.Account
+					PT string `json:"paging_token"`
+				} `json:"records"`
+			} `json:"_embedded"`
+		}
 
-	txs = obj.Embedded.Records
+		url := baseUrl
+		if cursor != "" {
+			url += "&cursor=" + cursor
+		}
 
-	return
+		err := horizonGetJson(url, &obj)
+		if err != nil {
+			return res, err
+		}
+
+		n := len(obj.Embedded.Records)
+
+		for i := range obj.Embedded.Records {
+			res = append(res, obj.Embedded.Records[i].Account)
+		}
+
+		if n < 200 {
+			break
+		}
+
+		cursor = obj.Embedded.Records[n-1].PT
+	}
+
+	return res, nil
 }
 
 func printHorizonError(action string, err error) {
 	if err != nil {
-		fmt.Println("%s failed, error details:", action)
+		fmt.Printf("%s failed, error details:\n", action)
 		if herr, ok := err.(*horizon.Error); ok {
 			fmt.Println(herr.Problem.Title)
 			fmt.Println(herr.Problem.Detail)
@@ -139,35 +192,72 @@ func printTransactionResults( txr horizon.TransactionSuccess) {
 }
 
 
-func tx_setup( src string ) (tx *build.TransactionBuilder) {
-	acc, err := loadAccount(src)
+// sequence numbers used for transactions created in offline mode, indexed by account
+var g_offlineSequences = make(map[string]uint64)
 
-	if err != nil {
-		panic(err)
+// prompts for the current sequence number of an account in offline mode
+func enterSequenceNumber(adr string) uint64 {
+	if seq, ok := g_offlineSequences[adr]; ok {
+		if getOk(fmt.Sprintf("Using account sequence number %d (of previous transaction)", seq)) {
+			return seq
+		}
 	}
 
-	if acc == nil {
-		// account does not exist
-		return nil
+	fmt.Printf("OFFLINE: Current sequence number of account %s required.\n", adr)
+	return getUint64("Account sequence number")
+}
+
+func tx_setup( src string ) (tx *build.TransactionBuilder) {
+	var seq uint64
+
+	if g_online {
+		acc, err := loadAccount(src)
+
+		if err != nil {
+			panic(err)
+		}
+
+		if acc == nil {
+			// account does not exist
+			return nil
+		}
+
+		seq, err = strconv.ParseUint(acc.Sequence, 10, 64)
+
+		if err != nil {
+			fmt.Println("Failed to parse account sequence number.")
+			panic(err)
+		}
+	} else {
+		adr := keypair.MustParse(src).Address()
+		seq = enterSequenceNumber(adr)
+		g_offlineSequences[adr] = seq + 1
 	}
 
-	seq, err := strconv.ParseUint(acc.Sequence, 10, 64)
+	tx, err := build.Transaction(
+		build.SourceAccount{src},
+		build.Sequence{seq+1},
+		g_network)
 
 	if err != nil {
-		fmt.Println("Failed to parse account sequence number.")
 		panic(err)
 	}
 
-	tx, err = build.Transaction(
+	return
+}
+
+// sets up a transaction with an explicit sequence number, the source account is not checked
+func tx_setupSequence( src string, seq uint64 ) *build.TransactionBuilder {
+	tx, err := build.Transaction(
 		build.SourceAccount{src},
-		build.Sequence{seq+1},
+		build.Sequence{seq},
 		g_network)
 
 	if err != nil {
 		panic(err)
 	}
 
-	return
+	return tx
 }
 
 func tx_createAccount(tx *build.TransactionBuilder, dst string, amount string) {
@@ -258,14 +348,20 @@ func tx_sign( tx *build.TransactionBuilder) (bool, build.TransactionEnvelopeBuil
 		panic(err)
 	}
 
-	if len(g_signers) > 0 {
-		for _, s := range g_signers {
-			txe.Mutate(build.Sign{s})
-		}
-		return true, txe
-	} else {
+	if signerCount() == 0 {
 		return false, txe
 	}
+
+	signers := signWithSigners(txe.E, nil)
+
+	if len(signers) == 0 {
+		return false, txe
+	}
+
+	journalAppend(&JournalEntry{Event: JournalEventSigned, Hash: transactionHashString(&txe.E.Tx),
+		Signers: signers})
+
+	return true, txe
 }
 
 func tx_finalize( tx *build.TransactionBuilder ) {
@@ -282,22 +378,111 @@ func tx_transmit( txe build.TransactionEnvelopeBuilder ) {
 	tx_transmit_blob(txeB64)
 }
 
-func tx_transmit_blob( tx_blob string ) {
+// adds signatures of all selected signers (private keys and external signers) to the envelope,
+// signers for which relevant() returns false are skipped, a nil relevant() accepts all signers
+// returns public keys of the signers that added a signature
+func signWithSigners(txe *xdr.TransactionEnvelope, relevant func(id string) bool) []string {
+	hash, err := network.HashTransaction(&txe.Tx, g_network.Passphrase)
+	if err != nil {
+		panic(err)
+	}
+
+	var signers []string
+
+	for _, s := range activeSigners() {
+		id := s.PublicKey()
+
+		if relevant != nil && !relevant(id) {
+			continue
+		}
+
+		sig, err := s.Sign(txe, hash)
+		if err != nil {
+			fmt.Printf("Signing with key %s failed: %s\n", id, err.Error())
+			continue
+		}
+
+		if mergeSignatures(txe, []xdr.DecoratedSignature{sig}) > 0 {
+			signers = append(signers, id)
+		}
+	}
+
+	return signers
+}
+
+// signs the transaction envelope with all selected signers that are signers of the transaction
+// source accounts, see isSignerRelevant()
+// returns number of added signatures
+func signEnvelope(txe *xdr.TransactionEnvelope, accounts []*AccountSignatureStatus) int {
+	signers := signWithSigners(txe, func(id string) bool { return isSignerRelevant(accounts, id) })
+
+	if len(signers) > 0 {
+		journalAppend(&JournalEntry{Event: JournalEventSigned, Hash: transactionHashString(&txe.Tx),
+			Signers: signers})
+	}
+
+	return len(signers)
+}
+
+// submits a transaction blob, prints the result and records it in the transaction journal
+// returns a *horizon.Error if the transaction was rejected
+func tx_transmit_blob( tx_blob string ) (*horizon.TransactionSuccess, error) {
+	if !g_online {
+		fmt.Println("OFFLINE: Cannot submit transaction.")
+		return nil, errors.New("offline mode")
+	}
+
+	txe, err := decodeTransactionBlob(tx_blob)
+	if err != nil {
+		fmt.Printf("Invalid transaction blob: %s\n", err.Error())
+		return nil, err
+	}
+
+	hash := transactionHashString(&txe.Tx)
+
+	if !journalCheckDuplicateSubmission(hash) {
+		fmt.Println("Transaction aborted.")
+		return nil, errors.New("duplicate submission aborted")
+	}
+
 	resp, err := g_horizon.SubmitTransaction(tx_blob)
 	if err != nil {
 		fmt.Println("Failed to submit transaction. Horizon error details:")
+		result := err.Error()
 		if herr, ok := err.(*horizon.Error); ok {
 			fmt.Println(herr.Problem.Title)
 			fmt.Println(herr.Problem.Detail)
 			fmt.Println(string(herr.Problem.Extras["result_codes"]))
 			fmt.Println(herr.Error())
 
+			if codes, ok := herr.Problem.Extras["result_codes"]; ok {
+				result = herr.Problem.Title + " " + string(codes)
+			}
 		} else {
 			fmt.Println(err.Error())
 		}
-	} else {
-		printTransactionResults(resp)
+
+		journalAppend(&JournalEntry{Event: JournalEventFailed, Hash: hash, Result: result})
+
+		return nil, err
 	}
+
+	printTransactionResults(resp)
+
+	journalAppend(&JournalEntry{Event: JournalEventSubmitted, Hash: hash, Ledger: resp.Ledger})
+
+	return &resp, nil
+}
+
+// returns hex encoded hash of given transaction for the current network
+func transactionHashString(tx *xdr.Transaction) string {
+	hash, err := network.HashTransaction(tx, g_network.Passphrase)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(hash[:])
 }
 
 
@@ -625,21 +810,159 @@ func pretty_print_transaction( txe *xdr.TransactionEnvelope, acc string) {
 }
 
 
-func readTransactionBlob( fileName string) (string, error) {
+// Transaction files start with a header of "#@ <key>: <value>" lines recording the target network,
+// the transaction hash, the creation time, the source accounts that need to sign and optional notes,
+// followed by a commented transaction summary and the transaction blob.
+// Only the source accounts with their threshold category are recorded, not the signer keys and weights:
+// these are not known when the file is written offline and may change before it is signed.
+// Header lines are comments, so files remain readable by older versions.
+
+const txFileHeaderPrefix = "#@"
+
+type TransactionFileHeader struct {
+	network string
+	hash string
+	created time.Time
+	sources []string // source accounts whose signers must sign, with threshold category
+	notes []string
+}
+
+func newTransactionFileHeader(txe *xdr.TransactionEnvelope, notes []string) *TransactionFileHeader {
+	hdr := &TransactionFileHeader{network: g_network.Passphrase, hash: transactionHashString(&txe.Tx),
+		created: time.Now(), notes: notes}
+
+	for _, r := range transactionSourceAccounts(&txe.Tx) {
+		hdr.sources = append(hdr.sources, fmt.Sprintf("%s (%s threshold)", r.id, thresholdLevelToString(r.level)))
+	}
+
+	return hdr
+}
+
+func (hdr *TransactionFileHeader) write(fp io.Writer) error {
+	var err error
+
+	pr := func(key, value string) {
+		if err == nil {
+			_, err = fmt.Fprintf(fp, "%s %s: %s\n", txFileHeaderPrefix, key, value)
+		}
+	}
+
+	pr("Network", hdr.network)
+	pr("Hash", hdr.hash)
+	pr("Created", hdr.created.Format(time.RFC3339))
+	for _, s := range hdr.sources {
+		pr("Source Account", s)
+	}
+	for _, n := range hdr.notes {
+		pr("Note", n)
+	}
+
+	return err
+}
+
+// parses a header line, returns false if line is not a header line
+func (hdr *TransactionFileHeader) parseLine(line string) bool {
+	if !strings.HasPrefix(line, txFileHeaderPrefix) {
+		return false
+	}
+
+	f := strings.SplitN(line[len(txFileHeaderPrefix):], ":", 2)
+	if len(f) != 2 {
+		return true
+	}
+
+	value := strings.TrimSpace(f[1])
+
+	switch strings.TrimSpace(f[0]) {
+	case "Network":
+		hdr.network = value
+	case "Hash":
+		hdr.hash = value
+	case "Created":
+		hdr.created, _ = time.Parse(time.RFC3339, value)
+	case "Source Account":
+		hdr.sources = append(hdr.sources, value)
+	case "Note":
+		hdr.notes = append(hdr.notes, value)
+	}
+
+	return true
+}
+
+// checks that the transaction file targets the active network and the transaction matches the recorded hash
+// files without network information are only accepted on confirmation, hdr is nil for blobs not read from a file
+func (hdr *TransactionFileHeader) check(txe *xdr.TransactionEnvelope) error {
+	if hdr == nil {
+		return nil
+	}
+
+	if hdr.network == "" {
+		fmt.Println("ATTENTION: Transaction file has no network information, cannot verify target network.")
+		fmt.Printf("Active network is \"%s\".\n", networkToString(g_network.Passphrase))
+
+		if !getOk("Use transaction for the active network") {
+			return errors.New("target network of transaction not confirmed")
+		}
+
+		return nil
+	}
+
+	if hdr.network != g_network.Passphrase {
+		return fmt.Errorf("transaction is for network \"%s\", active network is \"%s\"",
+			networkToString(hdr.network), networkToString(g_network.Passphrase))
+	}
+
+	if hdr.hash != "" && hdr.hash != transactionHashString(&txe.Tx) {
+		return errors.New("transaction hash does not match hash recorded in file header")
+	}
+
+	return nil
+}
+
+func printTransactionFileHeader(hdr *TransactionFileHeader) {
+	if hdr == nil || hdr.network == "" {
+		return
+	}
+
+	var table [][]string
+
+	table = appendTableLine(table, "Network", networkToString(hdr.network))
+	if !hdr.created.IsZero() {
+		table = appendTableLine(table, "Created", hdr.created.Format(time.RFC3339))
+	}
+	for _, s := range hdr.sources {
+		table = appendTableLine(table, "Source Account", s)
+	}
+	for _, n := range hdr.notes {
+		table = appendTableLine(table, "Note", n)
+	}
+
+	printTable(table, 2, ": ")
+}
+
+// reads the transaction blob and file header from given file
+func readTransactionBlob( fileName string) (string, *TransactionFileHeader, error) {
 	fp, err := os.Open(fileName)
 
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
+	defer fp.Close()
+
+	hdr := new(TransactionFileHeader)
+
 	scan := bufio.NewScanner(fp)
 
-	
 	for scan.Scan() {
 		line := scan.Text()
 		
 		line = strings.TrimSpace(line)
 
+		if hdr.parseLine(line) {
+			continue
+		}
+
 		i := strings.Index(line, "#")
 		if i >= 0 {
 			line = line[0:i]
@@ -649,24 +972,31 @@ func readTransactionBlob( fileName string) (string, error) {
 			continue
 		}
 
-		fp.Close()
-		return line, nil
+		return line, hdr, nil
 	}
 
 	err = scan.Err()
+	if err == nil {
+		err = errors.New("no transaction blob found")
+	}
 
-	fp.Close()
-
-	return "", err
+	return "", nil, err
 }
 
-func writeTransactionBlob( blob string, txe *xdr.TransactionEnvelope, fileName string) error {
+func writeTransactionBlob( blob string, txe *xdr.TransactionEnvelope, fileName string, notes []string) error {
 	fp, err := os.Create(fileName)
 
 	if err != nil {
 		return err
 	}
 
+	err = newTransactionFileHeader(txe, notes).write(fp)
+
+	if err != nil {
+		fp.Close()
+		return err
+	}
+
 	print_transaction( txe, "#", fp)
 
 	_, err = fmt.Fprintf( fp, "%s\n", blob)
@@ -689,7 +1019,12 @@ func writeTransactionBlob( blob string, txe *xdr.TransactionEnvelope, fileName s
 // read signers (private keys) from given file.
 // '#' used for comments
 // lines not containing a valid private key are silently ignored
+// encrypted signers files are decrypted with a password or key file
 func readSignersFile( fileName string) (cnt int, err error) {
+	if isEncryptedSignersFile(fileName) {
+		return readEncryptedSignersFile(fileName)
+	}
+
 	cnt = 0
 	fp, err := os.Open(fileName)
 
@@ -0,0 +1,676 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mua69/stellarwallet"
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/build"
+	"github.com/stellar/go/xdr"
+	"gopkg.in/yaml.v2"
+)
+
+// Transaction specifications describe a transaction in YAML, e.g.:
+//
+//   source: payroll               # wallet account description or public key
+//   sequence: auto                # auto, auto+N or an explicit sequence number
+//   fee: 100                      # base fee per operation in stroops (optional)
+//   time_bounds:                  # RFC3339 time, unix time or +duration relative to now (optional)
+//     max: +24h
+//   memo:
+//     type: text                  # text, id, hash or return
+//     value: salary
+//   operations:
+//     - type: payment
+//       destination: alice        # wallet or address book account description or public key
+//       asset: EURT               # XLM, wallet asset code or CODE:ISSUER
+//       amount: "100.50"
+//
+// Supported operation types: payment, create_account, change_trust, remove_trust, set_inflation,
+// sell_offer, account_merge, manage_data.
+//
+// A sell_offer with an offer_id and amount 0 deletes the offer. Binary manage_data values are
+// base64 encoded and marked with "encoding: base64".
+
+const (
+	TxSpecOpPayment       = "payment"
+	TxSpecOpCreateAccount = "create_account"
+	TxSpecOpChangeTrust   = "change_trust"
+	TxSpecOpRemoveTrust   = "remove_trust"
+	TxSpecOpSetInflation  = "set_inflation"
+	TxSpecOpSellOffer     = "sell_offer"
+	TxSpecOpAccountMerge  = "account_merge"
+	TxSpecOpManageData    = "manage_data"
+)
+
+const TxSpecEncodingBase64 = "base64"
+
+type TxSpec struct {
+	Source     string            `yaml:"source"`
+	Sequence   string            `yaml:"sequence,omitempty"`
+	Fee        uint32            `yaml:"fee,omitempty"`
+	TimeBounds *TxSpecTimeBounds `yaml:"time_bounds,omitempty"`
+	Memo       *TxSpecMemo       `yaml:"memo,omitempty"`
+	Operations []*TxSpecOp       `yaml:"operations"`
+}
+
+type TxSpecTimeBounds struct {
+	Min string `yaml:"min,omitempty"`
+	Max string `yaml:"max,omitempty"`
+}
+
+type TxSpecMemo struct {
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+}
+
+type TxSpecOp struct {
+	Type        string `yaml:"type"`
+	Destination string `yaml:"destination,omitempty"`
+	Asset       string `yaml:"asset,omitempty"`
+	Amount      string `yaml:"amount,omitempty"`
+	Selling     string `yaml:"selling,omitempty"`
+	Buying      string `yaml:"buying,omitempty"`
+	Price       string `yaml:"price,omitempty"`
+	OfferId     uint64 `yaml:"offer_id,omitempty"`
+	Name        string `yaml:"name,omitempty"`
+	Value       string `yaml:"value,omitempty"`
+	Encoding    string `yaml:"encoding,omitempty"`
+}
+
+func walletAccountsAndAddressBook() []*stellarwallet.Account {
+	if g_wallet == nil {
+		return nil
+	}
+
+	return append(g_wallet.Accounts(), g_wallet.AddressBook()...)
+}
+
+// resolves a public key or the description of a wallet or address book account
+// returns the public key and the wallet account, if any
+func resolveAccountAlias(s string) (string, *stellarwallet.Account, error) {
+	if s == "" {
+		return "", nil, errors.New("missing account")
+	}
+
+	if isValidPublicKey(s) {
+		var acc *stellarwallet.Account
+		if g_wallet != nil {
+			acc = g_wallet.FindAccountByPublicKey(s)
+		}
+		return s, acc, nil
+	}
+
+	var found *stellarwallet.Account
+
+	for _, a := range walletAccountsAndAddressBook() {
+		if a.Description() == s {
+			if found != nil && found.PublicKey() != a.PublicKey() {
+				return "", nil, fmt.Errorf("account alias \"%s\" is ambiguous", s)
+			}
+			found = a
+		}
+	}
+
+	if found == nil {
+		return "", nil, fmt.Errorf("unknown account \"%s\"", s)
+	}
+
+	return found.PublicKey(), found, nil
+}
+
+// returns the wallet description of an account if it identifies the account uniquely, the public key otherwise
+func accountAlias(adr string) string {
+	alias := ""
+
+	for _, a := range walletAccountsAndAddressBook() {
+		if a.PublicKey() == adr && a.Description() != "" {
+			alias = a.Description()
+			break
+		}
+	}
+
+	if alias == "" || isValidPublicKey(alias) {
+		return adr
+	}
+
+	if res, _, err := resolveAccountAlias(alias); err != nil || res != adr {
+		return adr
+	}
+
+	return alias
+}
+
+// resolves an asset given as XLM, wallet asset code or CODE:ISSUER
+func resolveAssetSpec(s string) (*Asset, error) {
+	if s == "XLM" || s == "native" {
+		return newNativeAsset(), nil
+	}
+
+	f := strings.SplitN(s, ":", 2)
+
+	if err := stellarwallet.CheckAssetId(f[0]); err != nil {
+		return nil, fmt.Errorf("invalid asset code \"%s\": %s", f[0], err.Error())
+	}
+
+	if len(f) == 2 {
+		issuer, _, err := resolveAccountAlias(f[1])
+		if err != nil {
+			return nil, fmt.Errorf("asset %s: %s", s, err.Error())
+		}
+		return newAsset(issuer, f[0]), nil
+	}
+
+	var found *stellarwallet.Asset
+
+	if g_wallet != nil {
+		for _, a := range g_wallet.Assets() {
+			if a.AssetId() == f[0] {
+				if found != nil {
+					return nil, fmt.Errorf("asset code %s is ambiguous, use CODE:ISSUER", f[0])
+				}
+				found = a
+			}
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("unknown asset %s, use CODE:ISSUER", f[0])
+	}
+
+	return newAssetFrom(found), nil
+}
+
+func xdrAssetToAsset(a xdr.Asset) *Asset {
+	switch a.Type {
+	case xdr.AssetTypeAssetTypeCreditAlphanum4:
+		return newAsset(rawPublicKeyToString(a.AlphaNum4.Issuer), strings.TrimRight(string(a.AlphaNum4.AssetCode[:]), "\x00"))
+	case xdr.AssetTypeAssetTypeCreditAlphanum12:
+		return newAsset(rawPublicKeyToString(a.AlphaNum12.Issuer), strings.TrimRight(string(a.AlphaNum12.AssetCode[:]), "\x00"))
+	}
+
+	return newNativeAsset()
+}
+
+// returns the asset as XLM, wallet asset code (if unique) or CODE:ISSUER
+func assetSpecString(asset *Asset) string {
+	if asset.isNative() {
+		return "XLM"
+	}
+
+	if a, err := resolveAssetSpec(asset.Code()); err == nil && a.isEqual(asset) {
+		return asset.Code()
+	}
+
+	return asset.Code() + ":" + accountAlias(asset.Issuer())
+}
+
+// parses a time bound: RFC3339 time, unix time or duration relative to now (+1h)
+func parseTimeBound(s string) (uint64, error) {
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+
+	if strings.HasPrefix(s, "+") {
+		d, err := time.ParseDuration(s[1:])
+		if err != nil {
+			return 0, err
+		}
+		return uint64(time.Now().Add(d).Unix()), nil
+	}
+
+	if t, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(t.Unix()), nil
+}
+
+// parses a positive amount, 0 is accepted if allowZero is set
+func parseSpecAmount(s string, allowZero bool) (*big.Rat, error) {
+	a, err := amount.Parse(s)
+
+	if err != nil || a < 0 || (a == 0 && !allowZero) {
+		return nil, fmt.Errorf("invalid amount \"%s\"", s)
+	}
+
+	return big.NewRat(int64(a), 1), nil
+}
+
+func readTxSpec(fileName string) (*TxSpec, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := new(TxSpec)
+
+	err = yaml.UnmarshalStrict(data, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// sets up the transaction builder for the source account and sequence number strategy of the specification
+func (spec *TxSpec) setup(src string) (*build.TransactionBuilder, error) {
+	seq := strings.TrimSpace(spec.Sequence)
+
+	if seq == "" || strings.HasPrefix(seq, "auto") {
+		var offset uint64
+
+		if seq != "" && seq != "auto" {
+			if !strings.HasPrefix(seq, "auto+") {
+				return nil, fmt.Errorf("invalid sequence \"%s\"", seq)
+			}
+			n, err := strconv.ParseUint(seq[len("auto+"):], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sequence \"%s\"", seq)
+			}
+			offset = n
+		}
+
+		tx := tx_setup(src)
+		if tx == nil {
+			return nil, errors.New("source account does not exist")
+		}
+
+		if offset > 0 {
+			tx.Mutate(build.Sequence{uint64(tx.TX.SeqNum) + offset})
+		}
+
+		return tx, nil
+	}
+
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sequence \"%s\"", seq)
+	}
+
+	return tx_setupSequence(src, n), nil
+}
+
+func (spec *TxSpec) compileMemo(tx *build.TransactionBuilder) error {
+	m := spec.Memo
+
+	if m == nil || m.Type == "" || m.Type == "none" {
+		return nil
+	}
+
+	switch m.Type {
+	case "text":
+		if len(m.Value) > 28 {
+			return errors.New("memo text exceeds 28 bytes")
+		}
+		tx_memoText(tx, m.Value)
+
+	case "id":
+		id, err := strconv.ParseUint(m.Value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid memo id \"%s\"", m.Value)
+		}
+		tx_memoID(tx, id)
+
+	case "hash", "return":
+		var hash [32]byte
+		b, err := hex.DecodeString(m.Value)
+		if err != nil || len(b) != len(hash) {
+			return fmt.Errorf("invalid memo hash \"%s\"", m.Value)
+		}
+		copy(hash[:], b)
+		if m.Type == "hash" {
+			tx_memoHash(tx, hash)
+		} else {
+			tx_memoRetHash(tx, hash)
+		}
+
+	default:
+		return fmt.Errorf("invalid memo type \"%s\"", m.Type)
+	}
+
+	return nil
+}
+
+func (op *TxSpecOp) compile(tx *build.TransactionBuilder) error {
+	var dst string
+	var err error
+
+	switch op.Type {
+	case TxSpecOpPayment, TxSpecOpCreateAccount, TxSpecOpSetInflation, TxSpecOpAccountMerge:
+		dst, _, err = resolveAccountAlias(op.Destination)
+		if err != nil {
+			return fmt.Errorf("destination: %s", err.Error())
+		}
+	}
+
+	switch op.Type {
+	case TxSpecOpPayment:
+		asset, err := resolveAssetSpec(op.Asset)
+		if err != nil {
+			return err
+		}
+		amnt, err := parseSpecAmount(op.Amount, false)
+		if err != nil {
+			return err
+		}
+		if asset.isNative() {
+			tx_payment(tx, dst, amountToString(amnt))
+		} else {
+			tx_payment_asset(tx, dst, asset, amnt)
+		}
+
+	case TxSpecOpCreateAccount:
+		amnt, err := parseSpecAmount(op.Amount, false)
+		if err != nil {
+			return err
+		}
+		tx_createAccount(tx, dst, amountToString(amnt))
+
+	case TxSpecOpChangeTrust, TxSpecOpRemoveTrust:
+		asset, err := resolveAssetSpec(op.Asset)
+		if err != nil {
+			return err
+		}
+		if asset.isNative() {
+			return errors.New("trust line for native asset")
+		}
+		if op.Type == TxSpecOpChangeTrust {
+			tx_addTrustLine(tx, asset.toHorizonAsset())
+		} else {
+			tx.Mutate(build.RemoveTrust(asset.Code(), asset.Issuer()))
+		}
+
+	case TxSpecOpSetInflation:
+		tx_inflationDestination(tx, dst)
+
+	case TxSpecOpSellOffer:
+		selling, err := resolveAssetSpec(op.Selling)
+		if err != nil {
+			return fmt.Errorf("selling: %s", err.Error())
+		}
+		buying, err := resolveAssetSpec(op.Buying)
+		if err != nil {
+			return fmt.Errorf("buying: %s", err.Error())
+		}
+		price, ok := new(big.Rat).SetString(op.Price)
+		if !ok || price.Sign() <= 0 {
+			return fmt.Errorf("invalid price \"%s\"", op.Price)
+		}
+		// amount 0 deletes an existing offer
+		amnt, err := parseSpecAmount(op.Amount, op.OfferId != 0)
+		if err != nil {
+			return err
+		}
+		tx_addSellOrder(tx, selling, buying, price, amnt, op.OfferId)
+
+	case TxSpecOpAccountMerge:
+		tx.Mutate(build.AccountMerge(build.Destination{dst}))
+
+	case TxSpecOpManageData:
+		value := []byte(op.Value)
+		switch op.Encoding {
+		case "":
+		case TxSpecEncodingBase64:
+			value, err = base64.StdEncoding.DecodeString(op.Value)
+			if err != nil {
+				return fmt.Errorf("invalid base64 data entry value: %s", err.Error())
+			}
+		default:
+			return fmt.Errorf("unsupported data entry value encoding \"%s\"", op.Encoding)
+		}
+		if op.Name == "" || len(op.Name) > 64 || len(value) > 64 {
+			return errors.New("invalid data entry name or value")
+		}
+		if len(value) == 0 {
+			tx.Mutate(build.ClearData(op.Name))
+		} else {
+			tx.Mutate(build.SetData(op.Name, value))
+		}
+
+	default:
+		return fmt.Errorf("unsupported operation type \"%s\"", op.Type)
+	}
+
+	return nil
+}
+
+// compiles the specification with the tx_* builders
+// returns the transaction builder and the source account (wallet account or public key)
+func (spec *TxSpec) compile() (*build.TransactionBuilder, *stellarwallet.Account, string, error) {
+	src, acc, err := resolveAccountAlias(spec.Source)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("source: %s", err.Error())
+	}
+
+	if len(spec.Operations) == 0 {
+		return nil, nil, "", errors.New("no operations")
+	}
+
+	tx, err := spec.setup(src)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if spec.Fee > 0 {
+		tx.Mutate(build.BaseFee{uint64(spec.Fee)})
+	}
+
+	if tb := spec.TimeBounds; tb != nil {
+		min, err := parseTimeBound(tb.Min)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("time_bounds min: %s", err.Error())
+		}
+		max, err := parseTimeBound(tb.Max)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("time_bounds max: %s", err.Error())
+		}
+		if max != 0 && max < min {
+			return nil, nil, "", errors.New("time_bounds: max before min")
+		}
+		tx.Mutate(build.Timebounds{min, max})
+	}
+
+	if err = spec.compileMemo(tx); err != nil {
+		return nil, nil, "", err
+	}
+
+	for i, op := range spec.Operations {
+		if err = op.compile(tx); err != nil {
+			return nil, nil, "", fmt.Errorf("operation %d: %s", i+1, err.Error())
+		}
+	}
+
+	return tx, acc, src, nil
+}
+
+func decompileOperation(op *xdr.Operation) (*TxSpecOp, error) {
+	if op.SourceAccount != nil {
+		return nil, errors.New("operation source accounts are not supported")
+	}
+
+	body := &op.Body
+
+	switch body.Type {
+	case xdr.OperationTypePayment:
+		return &TxSpecOp{Type: TxSpecOpPayment, Destination: accountAlias(rawPublicKeyToString(body.PaymentOp.Destination)),
+			Asset: assetSpecString(xdrAssetToAsset(body.PaymentOp.Asset)), Amount: amount.String(body.PaymentOp.Amount)}, nil
+
+	case xdr.OperationTypeCreateAccount:
+		return &TxSpecOp{Type: TxSpecOpCreateAccount, Destination: accountAlias(rawPublicKeyToString(body.CreateAccountOp.Destination)),
+			Amount: amount.String(body.CreateAccountOp.StartingBalance)}, nil
+
+	case xdr.OperationTypeChangeTrust:
+		asset := assetSpecString(xdrAssetToAsset(body.ChangeTrustOp.Line))
+		switch body.ChangeTrustOp.Limit {
+		case math.MaxInt64:
+			return &TxSpecOp{Type: TxSpecOpChangeTrust, Asset: asset}, nil
+		case 0:
+			return &TxSpecOp{Type: TxSpecOpRemoveTrust, Asset: asset}, nil
+		}
+		return nil, errors.New("trust line limits are not supported")
+
+	case xdr.OperationTypeSetOptions:
+		o := body.SetOptionsOp
+		if o.InflationDest == nil || o.ClearFlags != nil || o.SetFlags != nil || o.MasterWeight != nil ||
+			o.LowThreshold != nil || o.MedThreshold != nil || o.HighThreshold != nil || o.HomeDomain != nil ||
+			o.Signer != nil {
+			return nil, errors.New("set options is only supported for the inflation destination")
+		}
+		return &TxSpecOp{Type: TxSpecOpSetInflation, Destination: accountAlias(rawPublicKeyToString(*o.InflationDest))}, nil
+
+	case xdr.OperationTypeManageSellOffer:
+		o := body.ManageSellOfferOp
+		return &TxSpecOp{Type: TxSpecOpSellOffer, Selling: assetSpecString(xdrAssetToAsset(o.Selling)),
+			Buying: assetSpecString(xdrAssetToAsset(o.Buying)),
+			Price: big.NewRat(int64(o.Price.N), int64(o.Price.D)).RatString(),
+			Amount: amount.String(o.Amount), OfferId: uint64(o.OfferId)}, nil
+
+	case xdr.OperationTypeAccountMerge:
+		return &TxSpecOp{Type: TxSpecOpAccountMerge, Destination: accountAlias(rawPublicKeyToString(*body.Destination))}, nil
+
+	case xdr.OperationTypeManageData:
+		o := body.ManageDataOp
+		op := &TxSpecOp{Type: TxSpecOpManageData, Name: string(o.DataName)}
+		if o.DataValue != nil {
+			if utf8.Valid(*o.DataValue) {
+				op.Value = string(*o.DataValue)
+			} else {
+				op.Value = base64.StdEncoding.EncodeToString(*o.DataValue)
+				op.Encoding = TxSpecEncodingBase64
+			}
+		}
+		return op, nil
+	}
+
+	opType, _ := opToString(*op)
+
+	return nil, fmt.Errorf("operation \"%s\" is not supported", opType)
+}
+
+// converts a transaction to a specification, accounts and assets known to the wallet are replaced by their aliases
+func decompileTransaction(tx *xdr.Transaction) (*TxSpec, error) {
+	spec := &TxSpec{Source: accountAlias(rawPublicKeyToString(tx.SourceAccount)),
+		Sequence: strconv.FormatUint(uint64(tx.SeqNum), 10)}
+
+	if len(tx.Operations) > 0 {
+		spec.Fee = uint32(tx.Fee) / uint32(len(tx.Operations))
+	}
+
+	if tb := tx.TimeBounds; tb != nil {
+		spec.TimeBounds = &TxSpecTimeBounds{}
+		if tb.MinTime != 0 {
+			spec.TimeBounds.Min = time.Unix(int64(tb.MinTime), 0).UTC().Format(time.RFC3339)
+		}
+		if tb.MaxTime != 0 {
+			spec.TimeBounds.Max = time.Unix(int64(tb.MaxTime), 0).UTC().Format(time.RFC3339)
+		}
+	}
+
+	switch tx.Memo.Type {
+	case xdr.MemoTypeMemoText:
+		spec.Memo = &TxSpecMemo{"text", *tx.Memo.Text}
+	case xdr.MemoTypeMemoId:
+		spec.Memo = &TxSpecMemo{"id", strconv.FormatUint(uint64(*tx.Memo.Id), 10)}
+	case xdr.MemoTypeMemoHash:
+		spec.Memo = &TxSpecMemo{"hash", hex.EncodeToString(tx.Memo.Hash[:])}
+	case xdr.MemoTypeMemoReturn:
+		spec.Memo = &TxSpecMemo{"return", hex.EncodeToString(tx.Memo.RetHash[:])}
+	}
+
+	for i := range tx.Operations {
+		op, err := decompileOperation(&tx.Operations[i])
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %s", i+1, err.Error())
+		}
+		spec.Operations = append(spec.Operations, op)
+	}
+
+	return spec, nil
+}
+
+func compileTransactionSpec() {
+	fileName := readLine("Transaction specification file")
+
+	if fileName == "" {
+		return
+	}
+
+	spec, err := readTxSpec(fileName)
+	if err != nil {
+		fmt.Printf("Failed to read transaction specification \"%s\": %s\n", fileName, err.Error())
+		return
+	}
+
+	tx, acc, src, err := spec.compile()
+	if err != nil {
+		fmt.Printf("Invalid transaction specification: %s\n", err.Error())
+		return
+	}
+
+	transactionFinalize(acc, src, tx)
+}
+
+func decompileTransactionBlob() {
+	input := readLine("Transaction file or blob")
+
+	if input == "" {
+		return
+	}
+
+	txe, _, err := readTransactionEnvelope(input)
+	if err != nil {
+		fmt.Printf("Invalid transaction: %s\n", err.Error())
+		return
+	}
+
+	spec, err := decompileTransaction(&txe.Tx)
+	if err != nil {
+		fmt.Printf("Cannot decompile transaction: %s\n", err.Error())
+		return
+	}
+
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("\n%s\n", string(data))
+
+	fileName := readLine("Write specification to file (hit enter to skip)")
+
+	if fileName == "" {
+		return
+	}
+
+	err = ioutil.WriteFile(fileName, data, 0644)
+	if err != nil {
+		fmt.Printf("Failed to write file \"%s\": %s\n", fileName, err.Error())
+		return
+	}
+
+	fmt.Printf("Transaction specification written to file: %s\n", fileName)
+}
+
+func txSpecMenu() {
+	menu := []MenuEntryCB{
+		{ compileTransactionSpec, "Compile Transaction Specification", true},
+		{ decompileTransactionBlob, "Decompile Transaction to Specification", true}}
+
+	runCallbackMenu(menu, "TRANSACTION SPECIFICATION: Select Action", true)
+}
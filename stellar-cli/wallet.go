@@ -40,17 +40,22 @@ func loadWallet() bool {
 		return false
 	}
 
+	g_walletFileHash = walletFileHash(data)
+
 	return true
 }
 
+// saves the wallet, the written file is checked with the wallet password only if the wallet is unlocked,
+// saving never asks for the password
 func saveWallet() bool {
-	data := g_wallet.ExportBase64()
+	lockWalletPassword()
+	defer unlockWalletPassword()
 
-	if data == "" {
-		panic("Export wallet failed")
-	}
+	return saveWalletWithPassword(&g_walletPassword)
+}
 
-	err := ioutil.WriteFile(g_walletPath, []byte(data), 0600)
+func saveWalletWithPassword(pw *string) bool {
+	err := saveWalletFile(pw)
 
 	if err != nil {
 		fmt.Printf("Failed to write wallet file \"%s\": %s\n", g_walletPath, err.Error())
@@ -87,6 +92,9 @@ func openOrCreateWallet() {
 		walletMenu := []MenuEntry{
 			{ "new", "Create New Wallet", true },
 			{ "recover", "Recover Wallet With Mnemonic Words", true},
+			{ "recover-partial", "Recover Wallet With Partially Known Mnemonic", true},
+			{ "recover-shares", "Recover Wallet From Mnemonic Shares", true},
+			{ "restore", "Restore Wallet From Backup File", true},
 			{ "no", "Continue Without Wallet", true}}
 		
 		choice := runMenu(walletMenu, false)
@@ -101,6 +109,15 @@ func openOrCreateWallet() {
 
 		case "recover":
 			recoverWallet()
+
+		case "recover-partial":
+			mnemonicRecoveryAssistant()
+
+		case "recover-shares":
+			recoverWalletFromShares()
+
+		case "restore":
+			restoreWalletFromBackup()
 		}
 		
 	}
@@ -120,13 +137,7 @@ func createNewWallet() {
 
 	fmt.Println("Mnemonic word list required to recover the wallet. Please copy and store in a safe place:")
 	
-	var table [][]string
-
-	for i := 0; i < 4; i++ {
-		table = appendTableLine(table, words[6*i], words[6*i+1], words[6*i+2], words[6*i+3], words[6*i+4], words[6*i+5]) 
-	}
-
-	printTable(table, 6, "  ")
+	printMnemonicWords(words)
 
 	fmt.Println()
 	fmt.Println("You may enter an optional mnemonic password. If provided, the password is required to recover the wallet with the mnemonic word list.")
@@ -141,10 +152,34 @@ func createNewWallet() {
 		panic("Failed to generate account")
 	}
 
+	for {
+		ok, cancelled := mnemonicQuiz(words, &wpw)
+		if ok {
+			break
+		}
+
+		if cancelled && getOk("Discard new wallet") {
+			for i := range words {
+				stellarwallet.EraseString(&words[i])
+			}
+			stellarwallet.EraseString(&wpw)
+			stellarwallet.EraseString(&pw)
+			g_wallet = nil
+			fmt.Println("Wallet creation cancelled, wallet was not saved.")
+			return
+		}
+
+		if getOk("Show mnemonic words again") {
+			printMnemonicWords(words)
+		}
+	}
+
+	stellarwallet.EraseString(&wpw)
+
 	fmt.Println("New wallet was successfully created.")
 	
 
-	if saveWallet() {
+	if saveWalletWithPassword(&pw) {
 		fmt.Printf("Wallet saved to: %s\n", g_walletPath)
 	} else {
 		fmt.Println("Failed to save wallet.")
@@ -232,29 +267,29 @@ func recoverWallet() {
 			fmt.Println("Invalid mnemonic words.")
 		}
 	}
+
+	finishWalletRecovery(&pw)
+
+	stellarwallet.EraseString(&pw)
 	
-	
-	if g_wallet.GenerateAccount(&pw) == nil {
+}
+
+// recovers accounts of the wallet created from a mnemonic and saves it
+func finishWalletRecovery(pw *string) {
+	if g_wallet.GenerateAccount(pw) == nil {
 		panic("Failed to generate account")
 	}
 
-	if g_online {
-		g_wallet.RecoverAccounts(&pw, 5, fundedCheck)
-	} else {
-		fmt.Println("OFFLINE: Cannot automatically recover accounts.")
-	}
+	discoverAccounts(pw)
 
 	fmt.Println("Wallet was successfully recovered.")
 	
 
-	if saveWallet() {
+	if saveWalletWithPassword(pw) {
 		fmt.Printf("Wallet saved to: %s\n", g_walletPath)
 	} else {
 		fmt.Println("Failed to save wallet.")
 	}
-
-	stellarwallet.EraseString(&pw)
-	
 }
 
 func walletMenu() {
@@ -265,8 +300,14 @@ func walletMenu() {
 		{ listAssets, "List Assets", true },
 		{ listTradingPairs, "List Trading Pairs", true },
 		{ accountMenu, "Manage Accounts", true },
+		{ discoverCoSignedAccounts, "Discover Co-Signed Accounts", g_online },
+		{ generatePaymentRequest, "Generate Payment Request URI (SEP-7)", true },
 		{ assetMenu, "Manage Assets", true },
 		{ tradingPairMenu, "Manage Trading Pairs", true },
+		{ verifyMnemonicBackup, "Verify Mnemonic Backup", true },
+		{ splitWalletMnemonic, "Split Mnemonic Into Shares (Shamir)", true },
+		{ backupMenu, "Backup, Export and Import", true },
+		{ walletBackupMenu, "Wallet File Backups", true },
 		{ changePassword, "Change Password", true}}
 
 		
@@ -278,8 +319,10 @@ func accountMenu() {
 	menu := []MenuEntryCB{
 		{ generateAccount, "Generate New Account", true},
 		{ addRandomAccount, "Add Random Account", true},
+		{ importPaperWallet, "Import Paper Wallet", true},
 		{ addWatchingAccount, "Add Watching Account", true},
 		{ addAddressBookAccount, "Add Address Book Account", true},
+		{ discoverWalletAccounts, "Discover HD Accounts", g_online},
 		{ changeAccountDescription, "Change Account Description", true},
 		{ changeAccountMemo, "Change Account Memo Text/ID", true},
 		{ deleteAccount, "Delete Account", true}}
@@ -401,6 +444,8 @@ func unlockWallet(optional bool) bool {
 
 	g_walletPasswordUnlockTime = time.Now()
 
+	journalWritePending()
+
 	return true
 }
 
@@ -473,14 +518,42 @@ func changePassword() {
 
 	var pw string
 	getPasswordWithConfirmation("New Wallet Password", true, &pw)
+	defer stellarwallet.EraseString(&pw)
+
+	// the journal is re-encrypted first, the password change is aborted if this fails
+	journalTmp, err := journalChangePassword(&g_walletPassword, &pw)
+	if err != nil {
+		fmt.Printf("Failed to re-encrypt transaction journal: %s\n", err.Error())
+		fmt.Println("Wallet password not changed.")
+		return
+	}
 
 	if !g_wallet.ChangePassword(&g_walletPassword, &pw) {
 		// should never happen
 		fmt.Println("Change wallet password failed.")
+		os.Remove(journalTmp)
+		return
+	}
+
+	if saveWalletWithPassword(&pw) {
+		err = journalCommitPassword(journalTmp)
+
+		if err == nil {
+			fmt.Println("Wallet password changed.")
+			lockWallet()
+			return
+		}
+
+		fmt.Printf("Failed to replace transaction journal: %s\n", err.Error())
+	}
+
+	// revert to the old password, wallet and journal must use the same password
+	os.Remove(journalTmp)
+
+	if g_wallet.ChangePassword(&pw, &g_walletPassword) && saveWalletWithPassword(&g_walletPassword) {
+		fmt.Println("Wallet password not changed.")
 	} else {
-		fmt.Println("Wallet password changed.")
-		lockWallet()
-		saveWallet()
+		fmt.Println("ATTENTION: Failed to revert wallet password change.")
 	}
 }
 
@@ -582,6 +655,10 @@ func selectAccount(prompt string, enterAccountOption bool, accounts []*stellarwa
 	return nil
 }
 
+func isSeedAccount(a *stellarwallet.Account) bool {
+	return a.Type() == stellarwallet.AccountTypeSEP0005 || a.Type() == stellarwallet.AccountTypeRandom
+}
+
 func selectSeedAccount(prompt string, enterAccountOption bool) *stellarwallet.Account {
 	if g_wallet != nil {
 		return selectAccount(prompt, enterAccountOption, g_wallet.SeedAccounts())
@@ -590,6 +667,29 @@ func selectSeedAccount(prompt string, enterAccountOption bool) *stellarwallet.Ac
 	}
 }
 
+func isWatchingAccount(a *stellarwallet.Account) bool {
+	return a.Type() == stellarwallet.AccountTypeWatching
+}
+
+// selects an account usable as transaction source: seed accounts and watching accounts
+func selectSourceAccount(prompt string, enterAccountOption bool) *stellarwallet.Account {
+	if g_wallet != nil {
+		var accounts []*stellarwallet.Account
+
+		accounts = append(accounts, g_wallet.SeedAccounts()...)
+
+		for _, a := range g_wallet.Accounts() {
+			if isWatchingAccount(a) {
+				accounts = append(accounts, a)
+			}
+		}
+
+		return selectAccount(prompt, enterAccountOption, accounts)
+	} else {
+		return nil
+	}
+}
+
 func selectAnyAccount(prompt string, enterAccountOption bool) *stellarwallet.Account {
 	if g_wallet != nil {
 		accounts := g_wallet.Accounts()
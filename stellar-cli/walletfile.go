@@ -0,0 +1,495 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mua69/stellarwallet"
+)
+
+// The wallet file is written crash-safe: the new content is written to a temporary file, synced,
+// re-imported and integrity checked, and finally renamed to the wallet file. Before the wallet file is
+// replaced, the previous version is copied to a timestamped backup <wallet path>.bak-<time>, the last
+// g_walletBackupCount backups are kept.
+//
+// Concurrent stellar-cli instances are serialized by an advisory lock on <wallet path>.lock held while
+// the wallet file is written. The hash of the wallet file is recorded on load and save, if the file was
+// modified by another instance in between, the user is asked to merge, overwrite or abort.
+
+const walletBackupTimeFormat = "20060102-150405"
+
+const walletLockTimeout = 10 * time.Second
+
+type WalletFileBackup struct {
+	fileName string
+	time time.Time
+	size int64
+}
+
+func walletBackupPrefix() string {
+	return g_walletPath + ".bak-"
+}
+
+// writes data to fileName and syncs it to disk
+func writeFileSync(fileName string, data []byte, perm os.FileMode) error {
+	fp, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = fp.Write(data)
+	if err == nil {
+		err = fp.Sync()
+	}
+	if err != nil {
+		fp.Close()
+		return err
+	}
+
+	return fp.Close()
+}
+
+func copyFileSync(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return out.Close()
+}
+
+// syncs the directory holding fileName so that a rename is persisted, errors are ignored
+// as not all platforms support syncing directories
+func syncDir(fileName string) {
+	dir, err := os.Open(filepath.Dir(fileName))
+	if err != nil {
+		return
+	}
+
+	dir.Sync()
+	dir.Close()
+}
+
+func walletFileHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// acquires the lock of the wallet file, waits up to walletLockTimeout for other instances to release it
+func lockWalletFile() (*FileLock, error) {
+	start := time.Now()
+
+	for {
+		l, ok, err := tryLockFile(g_walletPath + ".lock")
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return l, nil
+		}
+
+		if time.Since(start) > walletLockTimeout {
+			return nil, errors.New("wallet file is locked by another stellar-cli instance")
+		}
+
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// generates HD accounts in w until all HD accounts of other exist, both wallets must share the mnemonic
+// HD accounts generated on the way that are not part of other are deleted again
+// returns the number of added HD accounts
+func mergeHdAccounts(w, other *stellarwallet.Wallet, pw *string) (int, error) {
+	missing := make(map[string]bool)
+
+	for _, a := range other.SeedAccounts() {
+		if a.Type() == stellarwallet.AccountTypeSEP0005 && w.FindAccountByPublicKey(a.PublicKey()) == nil {
+			missing[a.PublicKey()] = true
+		}
+	}
+
+	n := len(missing)
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	words := w.Bip39Mnemonic(pw)
+	otherWords := other.Bip39Mnemonic(pw)
+
+	same := strings.Join(words, " ") == strings.Join(otherWords, " ")
+
+	for i := range words {
+		stellarwallet.EraseString(&words[i])
+	}
+	for i := range otherWords {
+		stellarwallet.EraseString(&otherWords[i])
+	}
+
+	if !same {
+		return 0, errors.New("cannot merge, wallet file has HD accounts of a different mnemonic")
+	}
+
+	var extra []*stellarwallet.Account
+
+	for i := 0; len(missing) > 0 && i < len(other.SeedAccounts())+mnemonicVerifyMaxAccounts; i++ {
+		a := w.GenerateAccount(pw)
+		if a == nil {
+			break
+		}
+
+		if missing[a.PublicKey()] {
+			delete(missing, a.PublicKey())
+		} else if other.FindAccountByPublicKey(a.PublicKey()) == nil {
+			extra = append(extra, a)
+		}
+	}
+
+	if len(missing) > 0 {
+		return 0, fmt.Errorf("cannot merge, %d HD account(s) of the wallet file cannot be derived", len(missing))
+	}
+
+	for _, a := range extra {
+		w.DeleteAccount(a)
+	}
+
+	return n, nil
+}
+
+// adds accounts, assets and trading pairs of the wallet file data that are missing in the current wallet,
+// descriptions and memos of the current wallet take precedence
+// HD accounts are merged by deriving them, the merge is refused if that is not possible
+func mergeWalletFile(data []byte, pw *string) error {
+	w, err := stellarwallet.ImportBase64(string(data))
+	if err != nil {
+		return fmt.Errorf("cannot merge, failed to parse wallet file: %s", err.Error())
+	}
+
+	if !w.CheckPassword(pw) {
+		return errors.New("cannot merge, wallet file is encrypted with a different password")
+	}
+
+	// merge into a copy, the current wallet stays unchanged if merging fails
+	merged, err := stellarwallet.ImportBase64(g_wallet.ExportBase64())
+	if err != nil {
+		return fmt.Errorf("cannot merge, failed to copy wallet: %s", err.Error())
+	}
+
+	hd, err := mergeHdAccounts(merged, w, pw)
+	if err != nil {
+		return err
+	}
+
+	current := walletToBackup(g_wallet, nil)
+
+	other := walletToBackup(w, pw)
+	defer eraseBackupSecrets(other)
+
+	res := applyBackup(merged, other, pw)
+	applyBackup(merged, current, pw)
+
+	g_wallet = merged
+
+	fmt.Printf("Merged wallet file: derived %d HD account(s). ", hd)
+	printBackupApplyResult(res)
+
+	return nil
+}
+
+// checks whether the wallet file was modified by another process since it was loaded or saved,
+// the user may merge the changes into the current wallet, overwrite them or abort
+// must be called with the wallet file lock held
+func checkWalletFileModified(pw *string) error {
+	data, err := ioutil.ReadFile(g_walletPath)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if walletFileHash(data) == g_walletFileHash {
+		return nil
+	}
+
+	fmt.Printf("ATTENTION: Wallet file \"%s\" was modified by another process.\n", g_walletPath)
+
+	menu := []MenuEntry{
+		{ "merge", "Merge Changes Into Current Wallet and Save", true },
+		{ "overwrite", "Overwrite Wallet File, Changes of Other Process Are Lost", true },
+		{ "abort", "Abort Saving", true }}
+
+	switch runMenu(menu, false) {
+	case "merge":
+		if *pw == "" {
+			// saved with a locked wallet, pw refers to g_walletPassword
+			fmt.Println("Merging requires the wallet password.")
+			if !unlockWallet(true) {
+				return errors.New("saving aborted")
+			}
+			defer unlockWalletPassword()
+		}
+		return mergeWalletFile(data, pw)
+
+	case "overwrite":
+		return nil
+	}
+
+	return errors.New("saving aborted")
+}
+
+// checks that the wallet file can be imported and, if pw is not empty, passes the integrity check,
+// if expected is not empty the re-exported wallet must match it
+func verifyWalletFile(fileName string, pw *string, expected string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	w, err := stellarwallet.ImportBase64(string(data))
+	if err != nil {
+		return fmt.Errorf("import failed: %s", err.Error())
+	}
+
+	if expected != "" && w.ExportBase64() != expected {
+		return errors.New("re-exported wallet does not match")
+	}
+
+	if *pw != "" && !w.CheckIntegrity(pw) {
+		return errors.New("integrity check failed")
+	}
+
+	return nil
+}
+
+// returns existing wallet file backups, latest first
+func listWalletBackups() []*WalletFileBackup {
+	files, _ := filepath.Glob(walletBackupPrefix() + "*")
+
+	var res []*WalletFileBackup
+
+	for _, f := range files {
+		t, err := time.ParseInLocation(walletBackupTimeFormat, strings.TrimPrefix(f, walletBackupPrefix()), time.Local)
+		if err != nil {
+			continue
+		}
+
+		info, err := os.Stat(f)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		res = append(res, &WalletFileBackup{f, t, info.Size()})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].time.After(res[j].time) })
+
+	return res
+}
+
+// copies the current wallet file to a new timestamped backup and removes old backups
+func backupWalletFile() error {
+	if !checkWalletFile() {
+		return nil
+	}
+
+	name := walletBackupPrefix() + time.Now().Format(walletBackupTimeFormat)
+
+	if _, err := os.Stat(name); err == nil {
+		// backup of the same second exists already
+		return nil
+	}
+
+	if err := copyFileSync(g_walletPath, name); err != nil {
+		return err
+	}
+
+	backups := listWalletBackups()
+
+	for i := g_walletBackupCount; i < len(backups); i++ {
+		os.Remove(backups[i].fileName)
+	}
+
+	return nil
+}
+
+// writes the wallet crash-safe, the written file is verified with given wallet password
+func saveWalletFile(pw *string) error {
+	lock, err := lockWalletFile()
+	if err != nil {
+		return err
+	}
+
+	defer lock.unlock()
+
+	if err = checkWalletFileModified(pw); err != nil {
+		return err
+	}
+
+	data := g_wallet.ExportBase64()
+
+	if data == "" {
+		panic("Export wallet failed")
+	}
+
+	tmpName := g_walletPath + ".tmp"
+
+	if err := writeFileSync(tmpName, []byte(data), 0600); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := verifyWalletFile(tmpName, pw, data); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("verification of written wallet failed: %s", err.Error())
+	}
+
+	if g_walletBackupCount > 0 {
+		if err := backupWalletFile(); err != nil {
+			os.Remove(tmpName)
+			return fmt.Errorf("backup of previous wallet file failed: %s", err.Error())
+		}
+	}
+
+	if err := os.Rename(tmpName, g_walletPath); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	syncDir(g_walletPath)
+
+	g_walletFileHash = walletFileHash([]byte(data))
+
+	return nil
+}
+
+func showWalletBackups() {
+	backups := listWalletBackups()
+
+	if len(backups) == 0 {
+		fmt.Println("No wallet file backups found.")
+		return
+	}
+
+	fmt.Printf("\nWallet file backups of %s:\n", g_walletPath)
+
+	table := newCliTable(4)
+	table.setJustification(CliTableJustificationRight)
+
+	for i, b := range backups {
+		table.appendLine(fmt.Sprintf("%d", i+1), filepath.Base(b.fileName), b.time.Format(time.RFC3339),
+			fmt.Sprintf("%d bytes", b.size))
+	}
+
+	table.print()
+}
+
+// replaces the wallet file with a selected backup, the current wallet file is backed up first
+func restoreWalletBackup() {
+	showWalletBackups()
+
+	backups := listWalletBackups()
+
+	if len(backups) == 0 {
+		return
+	}
+
+	n := getInteger("Backup to restore (0 to cancel)")
+
+	if n <= 0 || n > len(backups) {
+		return
+	}
+
+	b := backups[n-1]
+
+	fmt.Println("Enter the wallet password that was valid when the backup was created.")
+
+	var pw string
+	defer stellarwallet.EraseString(&pw)
+
+	getPassword("Wallet Password", true, &pw)
+
+	if err := verifyWalletFile(b.fileName, &pw, ""); err != nil {
+		fmt.Printf("Backup %s cannot be restored: %s\n", filepath.Base(b.fileName), err.Error())
+		return
+	}
+
+	if !getOk(fmt.Sprintf("Replace wallet with backup from %s", b.time.Format(time.RFC3339))) {
+		return
+	}
+
+	lock, err := lockWalletFile()
+	if err != nil {
+		fmt.Printf("Failed to restore wallet file: %s\n", err.Error())
+		return
+	}
+
+	defer lock.unlock()
+
+	// copy the selected backup before backing up the current file, pruning may remove the selected backup
+	tmpName := g_walletPath + ".tmp"
+	os.Remove(tmpName)
+
+	if err = copyFileSync(b.fileName, tmpName); err != nil {
+		os.Remove(tmpName)
+		fmt.Printf("Failed to restore wallet file: %s\n", err.Error())
+		return
+	}
+
+	if err = backupWalletFile(); err != nil {
+		os.Remove(tmpName)
+		fmt.Printf("Backup of current wallet file failed: %s\n", err.Error())
+		return
+	}
+
+	if err = os.Rename(tmpName, g_walletPath); err != nil {
+		os.Remove(tmpName)
+		fmt.Printf("Failed to restore wallet file: %s\n", err.Error())
+		return
+	}
+
+	syncDir(g_walletPath)
+
+	lockWallet()
+
+	if loadWallet() {
+		fmt.Printf("Wallet restored from backup %s.\n", filepath.Base(b.fileName))
+	}
+}
+
+func walletBackupMenu() {
+	menu := []MenuEntryCB{
+		{ showWalletBackups, "List Wallet File Backups", true},
+		{ restoreWalletBackup, "Restore Wallet File Backup", true}}
+
+	runCallbackMenu(menu, "WALLET FILE BACKUPS: Select Action", false)
+}
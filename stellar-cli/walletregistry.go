@@ -0,0 +1,526 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mua69/stellarwallet"
+)
+
+// The wallet registry keeps a list of named wallet files, by default in ~/.stellar-cli/wallets.json:
+//
+//   { "wallets": [ { "name": "treasury", "path": "/home/user/wallets/treasury.dat" } ] }
+//
+// Wallets are opened from the registry at runtime, --wallet-path selects the wallet opened on start.
+
+type WalletRegistry struct {
+	Wallets []*WalletRegistryEntry `json:"wallets"`
+}
+
+type WalletRegistryEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// state of the active wallet, saved while another wallet is temporarily activated
+type WalletState struct {
+	wallet *stellarwallet.Wallet
+	path string
+	fileHash string
+}
+
+func walletRegistryPath() string {
+	if g_walletRegistryPath != "" {
+		return g_walletRegistryPath
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".stellar-cli", "wallets.json")
+}
+
+func loadWalletRegistry() (*WalletRegistry, error) {
+	reg := new(WalletRegistry)
+
+	fileName := walletRegistryPath()
+	if fileName == "" {
+		return nil, errors.New("cannot determine home directory")
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+
+	if os.IsNotExist(err) {
+		return reg, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, reg); err != nil {
+		return nil, fmt.Errorf("invalid wallet registry %s: %s", fileName, err.Error())
+	}
+
+	return reg, nil
+}
+
+func (reg *WalletRegistry) save() error {
+	fileName := walletRegistryPath()
+	if fileName == "" {
+		return errors.New("cannot determine home directory")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpName := fileName + ".tmp"
+
+	if err = writeFileSync(tmpName, append(data, '\n'), 0600); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, fileName)
+}
+
+func (reg *WalletRegistry) findByName(name string) *WalletRegistryEntry {
+	for _, e := range reg.Wallets {
+		if e.Name == name {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func (reg *WalletRegistry) findByPath(path string) *WalletRegistryEntry {
+	abs, _ := filepath.Abs(path)
+
+	for _, e := range reg.Wallets {
+		if e.Path == abs {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func (reg *WalletRegistry) add(name, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if reg.findByName(name) != nil {
+		return fmt.Errorf("wallet name \"%s\" is already registered", name)
+	}
+
+	if e := reg.findByPath(abs); e != nil {
+		return fmt.Errorf("wallet file is already registered as \"%s\"", e.Name)
+	}
+
+	reg.Wallets = append(reg.Wallets, &WalletRegistryEntry{name, abs})
+
+	return nil
+}
+
+func (reg *WalletRegistry) remove(e *WalletRegistryEntry) {
+	for i := range reg.Wallets {
+		if reg.Wallets[i] == e {
+			reg.Wallets = append(reg.Wallets[:i], reg.Wallets[i+1:]...)
+			return
+		}
+	}
+}
+
+// name of the active wallet for display: registry name or file name
+func activeWalletName() string {
+	if g_wallet == nil {
+		return ""
+	}
+
+	if reg, err := loadWalletRegistry(); err == nil {
+		if e := reg.findByPath(g_walletPath); e != nil {
+			return e.Name
+		}
+	}
+
+	return filepath.Base(g_walletPath)
+}
+
+func currentWalletState() *WalletState {
+	return &WalletState{g_wallet, g_walletPath, g_walletFileHash}
+}
+
+func (s *WalletState) activate() {
+	g_wallet = s.wallet
+	g_walletPath = s.path
+	g_walletFileHash = s.fileHash
+}
+
+func printWalletRegistry(reg *WalletRegistry) {
+	table := newCliTable(4)
+
+	active, _ := filepath.Abs(g_walletPath)
+
+	for i, e := range reg.Wallets {
+		status := ""
+
+		if g_wallet != nil && e.Path == active {
+			status = "active"
+		} else if _, err := os.Stat(e.Path); err != nil {
+			status = "missing"
+		}
+
+		table.appendLine(fmt.Sprintf("%d", i+1), e.Name, e.Path, status)
+	}
+
+	table.print()
+}
+
+func enterWalletName(reg *WalletRegistry) string {
+	for {
+		name := strings.TrimSpace(readLine("Wallet name (hit enter to cancel)"))
+
+		if name == "" || reg.findByName(name) == nil {
+			return name
+		}
+
+		fmt.Printf("Wallet name \"%s\" is already registered.\n", name)
+	}
+}
+
+// selects a registered wallet, returns nil if cancelled
+func selectRegisteredWallet(reg *WalletRegistry, prompt string) *WalletRegistryEntry {
+	if len(reg.Wallets) == 0 {
+		fmt.Println("No wallets registered.")
+		return nil
+	}
+
+	printWalletRegistry(reg)
+
+	n := getInteger(prompt + " (0 to cancel)")
+
+	if n <= 0 || n > len(reg.Wallets) {
+		return nil
+	}
+
+	return reg.Wallets[n-1]
+}
+
+func listRegisteredWallets() {
+	reg, err := loadWalletRegistry()
+	if err != nil {
+		fmt.Printf("Failed to load wallet registry: %s\n", err.Error())
+		return
+	}
+
+	if len(reg.Wallets) == 0 {
+		fmt.Printf("No wallets registered in %s.\n", walletRegistryPath())
+		return
+	}
+
+	fmt.Printf("\nRegistered wallets (%s):\n", walletRegistryPath())
+	printWalletRegistry(reg)
+}
+
+func closeActiveWallet() {
+	lockWallet()
+	clearSigners()
+
+	g_wallet = nil
+	g_walletFileHash = ""
+}
+
+// activates the wallet at path, the previous wallet stays active if loading fails
+func switchWallet(path string) bool {
+	prev := currentWalletState()
+
+	closeActiveWallet()
+
+	g_walletPath = path
+	openOrCreateWallet()
+
+	if g_wallet == nil {
+		fmt.Println("Wallet not opened.")
+		prev.activate()
+		return false
+	}
+
+	fmt.Printf("Active wallet: %s\n", activeWalletName())
+
+	return true
+}
+
+func openRegisteredWallet() {
+	reg, err := loadWalletRegistry()
+	if err != nil {
+		fmt.Printf("Failed to load wallet registry: %s\n", err.Error())
+		return
+	}
+
+	e := selectRegisteredWallet(reg, "Wallet to open")
+
+	if e == nil {
+		return
+	}
+
+	if _, err = os.Stat(e.Path); err != nil {
+		fmt.Printf("Wallet file %s not found.\n", e.Path)
+		return
+	}
+
+	switchWallet(e.Path)
+}
+
+// creates a new wallet file and registers it
+func createRegisteredWallet() {
+	reg, err := loadWalletRegistry()
+	if err != nil {
+		fmt.Printf("Failed to load wallet registry: %s\n", err.Error())
+		return
+	}
+
+	name := enterWalletName(reg)
+	if name == "" {
+		return
+	}
+
+	path := strings.TrimSpace(readLine("Wallet file name"))
+	if path == "" {
+		return
+	}
+
+	if _, err = os.Stat(path); err == nil {
+		fmt.Printf("File \"%s\" exists, use Register Existing Wallet to add it.\n", path)
+		return
+	}
+
+	if !switchWallet(path) {
+		return
+	}
+
+	if err = reg.add(name, path); err == nil {
+		err = reg.save()
+	}
+
+	if err != nil {
+		fmt.Printf("Failed to register wallet: %s\n", err.Error())
+	}
+}
+
+// registers an existing wallet file, defaults to the active wallet
+func registerWallet() {
+	reg, err := loadWalletRegistry()
+	if err != nil {
+		fmt.Printf("Failed to load wallet registry: %s\n", err.Error())
+		return
+	}
+
+	path := ""
+
+	if g_wallet != nil && reg.findByPath(g_walletPath) == nil && getOk(fmt.Sprintf("Register active wallet %s", g_walletPath)) {
+		path = g_walletPath
+	} else {
+		path = strings.TrimSpace(readLine("Wallet file name (hit enter to cancel)"))
+		if path == "" {
+			return
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		_, err = stellarwallet.ImportBase64(string(data))
+	}
+
+	if err != nil {
+		fmt.Printf("Not a valid wallet file: %s\n", err.Error())
+		return
+	}
+
+	name := enterWalletName(reg)
+	if name == "" {
+		return
+	}
+
+	if err = reg.add(name, path); err == nil {
+		err = reg.save()
+	}
+
+	if err != nil {
+		fmt.Printf("Failed to register wallet: %s\n", err.Error())
+	} else {
+		fmt.Printf("Wallet \"%s\" registered.\n", name)
+	}
+}
+
+// removes a wallet from the registry, the wallet file is not deleted
+func unregisterWallet() {
+	reg, err := loadWalletRegistry()
+	if err != nil {
+		fmt.Printf("Failed to load wallet registry: %s\n", err.Error())
+		return
+	}
+
+	e := selectRegisteredWallet(reg, "Wallet to remove from registry")
+
+	if e == nil || !getOk(fmt.Sprintf("Remove wallet \"%s\" from registry (the wallet file is kept)", e.Name)) {
+		return
+	}
+
+	reg.remove(e)
+
+	if err = reg.save(); err != nil {
+		fmt.Printf("Failed to save wallet registry: %s\n", err.Error())
+	}
+}
+
+func closeWallet() {
+	if g_wallet == nil {
+		fmt.Println("No active wallet.")
+		return
+	}
+
+	name := activeWalletName()
+
+	closeActiveWallet()
+
+	fmt.Printf("Wallet \"%s\" closed.\n", name)
+}
+
+// copies selected address book entries and assets of the active wallet to another registered wallet
+func copyToWallet() {
+	reg, err := loadWalletRegistry()
+	if err != nil {
+		fmt.Printf("Failed to load wallet registry: %s\n", err.Error())
+		return
+	}
+
+	src := walletToBackup(g_wallet, nil)
+
+	var items []string
+	var accounts []*BackupAccount
+
+	for _, a := range src.Accounts {
+		if a.Type == BackupAccountTypeAddressBook {
+			accounts = append(accounts, a)
+			items = append(items, fmt.Sprintf("Address Book: %s %s", a.PublicKey, a.Description))
+		}
+	}
+
+	for _, a := range src.Assets {
+		items = append(items, fmt.Sprintf("Asset: %s %s %s", a.Code, a.Issuer, a.Description))
+	}
+
+	if len(items) == 0 {
+		fmt.Println("No address book entries or assets to copy.")
+		return
+	}
+
+	for i, s := range items {
+		fmt.Printf("%3d: %s\n", i+1, s)
+	}
+
+	var sel []int
+
+	for {
+		s := readLine("Select entries (e.g. 1,3-5, 'a' for all, hit enter to cancel)")
+
+		if s == "" {
+			return
+		}
+
+		sel, err = parseSelection(s, len(items))
+
+		if err == nil {
+			break
+		}
+
+		fmt.Printf("Invalid selection: %s\n", err.Error())
+	}
+
+	b := &WalletBackup{Format: backupFormatPublic, Version: backupVersion}
+
+	for _, i := range sel {
+		if i < len(accounts) {
+			b.Accounts = append(b.Accounts, accounts[i])
+		} else {
+			b.Assets = append(b.Assets, src.Assets[i-len(accounts)])
+		}
+	}
+
+	e := selectRegisteredWallet(reg, "Target wallet")
+
+	if e == nil {
+		return
+	}
+
+	active, _ := filepath.Abs(g_walletPath)
+
+	if e.Path == active {
+		fmt.Println("Target wallet is the active wallet.")
+		return
+	}
+
+	prev := currentWalletState()
+	defer prev.activate()
+
+	g_walletPath = e.Path
+
+	if !loadWallet() {
+		return
+	}
+
+	var pw string
+	defer stellarwallet.EraseString(&pw)
+
+	for {
+		getPassword(fmt.Sprintf("Wallet Password of \"%s\"", e.Name), false, &pw)
+
+		if pw == "" {
+			return
+		}
+
+		if g_wallet.CheckPassword(&pw) {
+			break
+		}
+
+		fmt.Println("Invalid password.")
+	}
+
+	res := applyBackup(g_wallet, b, &pw)
+	printBackupApplyResult(res)
+
+	if res.accounts+res.assets+res.updated > 0 {
+		if saveWalletWithPassword(&pw) {
+			fmt.Printf("Wallet \"%s\" saved.\n", e.Name)
+		}
+	}
+}
+
+func walletRegistryMenu() {
+	menu := []MenuEntryCB{
+		{ listRegisteredWallets, "List Wallets", true },
+		{ openRegisteredWallet, "Open Wallet", true },
+		{ createRegisteredWallet, "Create New Wallet", true },
+		{ registerWallet, "Register Existing Wallet", true },
+		{ unregisterWallet, "Remove Wallet From Registry", true },
+		{ copyToWallet, "Copy Address Book Entries/Assets to Other Wallet", g_wallet != nil },
+		{ closeWallet, "Close Active Wallet", g_wallet != nil }}
+
+	runCallbackMenu(menu, "WALLETS: Select Action", false)
+}